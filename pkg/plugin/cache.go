@@ -0,0 +1,190 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/rockset/rockset-go-client/openapi"
+)
+
+const (
+	// DefaultCacheBucket is what the time range of queries is aligned to in the cache key, unless set in the settings
+	DefaultCacheBucket = time.Minute
+	// maxCacheEntries is the most responses cached by a datasource
+	maxCacheEntries = 1000
+	// fromAlertHeader is set by Grafana on requests from alert rules, which must always query Rockset
+	fromAlertHeader = "FromAlert"
+)
+
+// resultCache caches the responses of queries, so dashboards with many viewers and a short refresh interval
+// don't send the same query to Rockset over and over. The zero value is an empty cache.
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	response backend.DataResponse
+	created  time.Time
+	expires  time.Time
+}
+
+// get returns the cached response for the key and when it was cached, if it hasn't expired
+func (c *resultCache) get(key string, now time.Time) (backend.DataResponse, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, found := c.entries[key]
+	if !found || !now.Before(e.expires) {
+		return backend.DataResponse{}, time.Time{}, false
+	}
+
+	return e.response, e.created, true
+}
+
+// set caches the response for the key, and when the cache is full, expired responses are removed first and
+// then the response which expires the soonest
+func (c *resultCache) set(key string, res backend.DataResponse, now time.Time, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+
+	if _, found := c.entries[key]; !found && len(c.entries) >= maxCacheEntries {
+		var oldest string
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+				continue
+			}
+			if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			delete(c.entries, oldest)
+		}
+	}
+
+	c.entries[key] = cacheEntry{response: res, created: now, expires: now.Add(ttl)}
+}
+
+// cachedQuery returns the cached response of the query, or runs it and caches the response if it succeeds.
+// Queries from alert rules and queries with a query plan are never cached.
+func (d *RocksetDatasource) cachedQuery(req *backend.QueryDataRequest, settings Settings, queryType string,
+	query backend.DataQuery, run func() backend.DataResponse) backend.DataResponse {
+	var qm MetricsQueryModel
+	if err := json.Unmarshal(query.JSON, &qm); err != nil || qm.QueryPlan != "" || req.Headers[fromAlertHeader] == "true" {
+		return run()
+	}
+
+	ttl := settings.cacheTTL()
+	if qm.CacheTTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(qm.CacheTTL); err != nil {
+			log.DefaultLogger.Warn("invalid cache TTL, not caching", "refId", query.RefID, "ttl", qm.CacheTTL)
+			return run()
+		}
+	}
+	if ttl <= 0 {
+		return run()
+	}
+
+	key, err := cacheKey(req.PluginContext.DataSourceInstanceSettings.UID, settings.VI, queryType, qm,
+		query.TimeRange, settings.cacheBucket())
+	if err != nil {
+		log.DefaultLogger.Error("failed to create cache key, not caching", "refId", query.RefID, "error", err.Error())
+		return run()
+	}
+	now := time.Now()
+	if res, created, found := d.cache.get(key, now); found {
+		log.DefaultLogger.Debug("cache hit", "refId", query.RefID, "key", key)
+		return withCacheStats(res, true, now.Sub(created))
+	}
+
+	res := run()
+	if res.Error == nil {
+		d.cache.set(key, copyResponse(res), now, ttl)
+	}
+
+	return withCacheStats(res, false, 0)
+}
+
+// cacheKey returns the key of the query, which is made from the SQL, the parameters as bound by buildQueryOptions,
+// with the time range aligned to the bucket, the VI and the datasource, as well as the columns, time offsets,
+// fill and downsampling which decide how the rows are turned into frames
+func cacheKey(uid, vi, queryType string, qm MetricsQueryModel, tr backend.TimeRange,
+	bucket time.Duration) (string, error) {
+	request := applyQueryOptions(qm.QueryText, buildQueryOptions(qm, tr.From.Truncate(bucket), tr.To.Truncate(bucket), vi))
+
+	b, err := json.Marshal(struct {
+		UID           string                `json:"uid"`
+		VI            *string               `json:"vi"`
+		QueryType     string                `json:"queryType"`
+		Request       *openapi.QueryRequest `json:"request"`
+		TimeField     string                `json:"timeField"`
+		LabelColumn   string                `json:"labelColumn"`
+		TimeOffsets   []string              `json:"timeOffsets"`
+		Fill          string                `json:"fill"`
+		Downsample    string                `json:"downsample"`
+		MaxDataPoints int32                 `json:"maxDataPoints"`
+	}{uid, request.VirtualInstance, queryType, request.QueryRequest, qm.QueryTimeField, qm.QueryLabelColumn,
+		qm.TimeOffsets, qm.Fill, qm.Downsample, qm.MaxDataPoints})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// copyResponse returns a copy of the response, with copies of the frames and their meta, so the cached frames
+// are never changed by what is done to the response afterwards
+func copyResponse(res backend.DataResponse) backend.DataResponse {
+	frames := make(data.Frames, len(res.Frames))
+	for i, frame := range res.Frames {
+		f := *frame
+		if frame.Meta != nil {
+			meta := *frame.Meta
+			meta.Stats = append([]data.QueryStat(nil), meta.Stats...)
+			meta.Notices = append([]data.Notice(nil), meta.Notices...)
+			f.Meta = &meta
+		}
+		frames[i] = &f
+	}
+	res.Frames = frames
+
+	return res
+}
+
+// withCacheStats returns a copy of the response, where the first frame has query stats which show whether it
+// is from the cache, and how old it is
+func withCacheStats(res backend.DataResponse, hit bool, age time.Duration) backend.DataResponse {
+	res = copyResponse(res)
+	if len(res.Frames) == 0 {
+		return res
+	}
+	if res.Frames[0].Meta == nil {
+		res.Frames[0].Meta = &data.FrameMeta{}
+	}
+	meta := res.Frames[0].Meta
+
+	if !hit {
+		meta.Stats = append(meta.Stats, data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "cache hit"}})
+		return res
+	}
+	meta.Stats = append(meta.Stats,
+		data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "cache hit"}, Value: 1},
+		data.QueryStat{FieldConfig: data.FieldConfig{DisplayName: "cache age", Unit: "s"}, Value: age.Seconds()},
+	)
+
+	return res
+}
@@ -0,0 +1,193 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/rockset/rockset-go-client/openapi"
+)
+
+const (
+	// MaxChunkedRows is the most rows returned by a query which is split into chunks
+	MaxChunkedRows = 1_000_000
+	// maxChunks is the most chunks the time range of a query can be split into
+	maxChunks = 100
+	// maxParallelChunks is the most chunks of a query which are executed at the same time
+	maxParallelChunks = 4
+)
+
+// chunkInterval parses the chunk interval of the query, which can use days like the time offsets, e.g. 1d.
+// A blank interval is 0, which doesn't split the query.
+func chunkInterval(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	interval, err := gtime.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chunk interval %q: %w", s, err)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("invalid chunk interval %q: it must be positive", s)
+	}
+
+	return interval, nil
+}
+
+// splitTimeRange splits the time range into chunks of the interval, which are aligned to the interval so
+// time buckets which are a fraction of the interval aren't split between chunks. A time range shorter than
+// the interval is a single chunk.
+func splitTimeRange(tr backend.TimeRange, interval time.Duration) ([]backend.TimeRange, error) {
+	if interval <= 0 || tr.To.Sub(tr.From) <= interval {
+		return []backend.TimeRange{tr}, nil
+	}
+
+	var chunks []backend.TimeRange
+	for from := tr.From; from.Before(tr.To); {
+		to := from.Truncate(interval).Add(interval)
+		if to.After(tr.To) {
+			to = tr.To
+		}
+		chunks = append(chunks, backend.TimeRange{From: from, To: to})
+		if len(chunks) > maxChunks {
+			return nil, fmt.Errorf("chunk interval %s splits the time range into more than %d chunks", interval, maxChunks)
+		}
+		from = to
+	}
+
+	return chunks, nil
+}
+
+// chunkResult is the response of the query for a chunk of the time range
+type chunkResult struct {
+	tr     backend.TimeRange
+	qr     openapi.QueryResponse
+	stream *rowStream
+	// rows are the rows of the response when it was streamed, as they aren't in the Results
+	rows []map[string]interface{}
+	err  error
+}
+
+// results returns the rows of the chunk
+func (r chunkResult) results() []map[string]interface{} {
+	if r.stream.streamed {
+		return r.rows
+	}
+
+	return r.qr.Results
+}
+
+// queryChunks executes the query for each chunk of the time range in parallel, and combines the responses in
+// the order of the chunks, up to the row limit of the query, or MaxChunkedRows. When the responses are streamed,
+// the max response bytes apply to the combined responses, and as the chunks are read at the same time, each
+// truncated chunk keeps the rows read before the limit. A failed chunk is reported as a notice, and only when
+// all chunks fail an error is returned.
+func queryChunks(ctx context.Context, rs RockClient, qm MetricsQueryModel, vi string,
+	chunks []backend.TimeRange) (openapi.QueryResponse, []data.Notice, error) {
+	results := make([]chunkResult, len(chunks))
+	read := new(atomic.Int64)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxParallelChunks)
+	for i, tr := range chunks {
+		wg.Add(1)
+		go func(i int, tr backend.TimeRange) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			// the decoder reuses the map of each row, so the streamed rows are copied
+			r := chunkResult{tr: tr}
+			r.stream = &rowStream{read: read, appendRow: func(row map[string]interface{}) error {
+				r.rows = append(r.rows, maps.Clone(row))
+				return nil
+			}}
+			options := buildQueryOptions(qm, tr.From, tr.To, vi)
+			r.qr, r.err = rs.Query(withRowStream(ctx, r.stream), qm.QueryText, options...)
+			results[i] = r
+		}(i, tr)
+	}
+	wg.Wait()
+
+	// the row limit applies to the combined result, rather than to each chunk
+	limit := MaxChunkedRows
+	if l := rowLimit(qm.RowLimit, maxRowLimitFrom(ctx)); l > 0 && int(l) < limit {
+		limit = int(l)
+	}
+
+	var combined openapi.QueryResponse
+	var notices []data.Notice
+	var failed error
+	var elapsed, throttled, truncated int64
+	succeeded := 0
+	for _, r := range results {
+		if r.err != nil {
+			log.DefaultLogger.Error("chunk failed", "from", r.tr.From, "to", r.tr.To, "error", r.err.Error())
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text: fmt.Sprintf("the query failed for %s to %s, so the rows are missing: %v",
+					r.tr.From.UTC().Format(time.RFC3339), r.tr.To.UTC().Format(time.RFC3339), r.err),
+			})
+			if failed == nil {
+				failed = r.err
+			}
+			continue
+		}
+		succeeded++
+
+		if combined.QueryId == nil {
+			combined.QueryId = r.qr.QueryId
+			combined.ColumnFields = r.qr.ColumnFields
+		}
+		// every chunk which hits the row limit has the same warning
+		for _, w := range r.qr.Warnings {
+			if !slices.Contains(combined.Warnings, w) {
+				combined.Warnings = append(combined.Warnings, w)
+			}
+		}
+		combined.QueryErrors = append(combined.QueryErrors, r.qr.QueryErrors...)
+		// the chunks are executed in parallel, so the elapsed time is the longest chunk
+		elapsed = max(elapsed, r.qr.Stats.GetElapsedTimeMs())
+		throttled += r.qr.Stats.GetThrottledTimeMicros()
+
+		rows := r.results()
+		if n := limit - len(combined.Results); len(rows) > n {
+			combined.Results = append(combined.Results, rows[:n]...)
+			if limit < MaxChunkedRows {
+				if w := rowLimitWarning(int32(limit)); !slices.Contains(combined.Warnings, w) {
+					combined.Warnings = append(combined.Warnings, w)
+				}
+				break
+			}
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("the result was truncated to %d rows", MaxChunkedRows),
+			})
+			break
+		}
+		combined.Results = append(combined.Results, rows...)
+		if r.stream.truncated {
+			truncated = r.stream.limit
+		}
+	}
+	if succeeded == 0 {
+		return combined, nil, failed
+	}
+	if truncated > 0 {
+		notices = append(notices, truncatedNotice(len(combined.Results), truncated))
+	}
+
+	combined.Stats = &openapi.QueryResponseStats{ElapsedTimeMs: &elapsed, ThrottledTimeMicros: &throttled}
+	combined.SetResultsTotalDocCount(int64(len(combined.Results)))
+
+	return combined, notices, nil
+}
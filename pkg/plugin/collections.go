@@ -0,0 +1,328 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// DefaultWorkspace is the workspace of collections which are referenced without a workspace
+const DefaultWorkspace = "commons"
+
+// collectionRef is a reference to a collection in a query
+type collectionRef struct {
+	workspace string
+	name      string
+}
+
+func (r collectionRef) String() string {
+	return r.workspace + "." + r.name
+}
+
+// tableKeywords are the keywords which are followed by a collection
+var tableKeywords = map[string]bool{
+	"FROM": true,
+	"JOIN": true,
+	"INTO": true,
+}
+
+// fromFunctions are the functions which use FROM in their arguments, e.g. EXTRACT(HOUR FROM _event_time)
+var fromFunctions = map[string]bool{
+	"EXTRACT":   true,
+	"TRIM":      true,
+	"SUBSTRING": true,
+	"POSITION":  true,
+	"OVERLAY":   true,
+}
+
+// clauseKeywords end the list of tables after FROM, JOIN or INTO
+var clauseKeywords = map[string]bool{
+	"JOIN":      true,
+	"WHERE":     true,
+	"GROUP":     true,
+	"HAVING":    true,
+	"ORDER":     true,
+	"LIMIT":     true,
+	"OFFSET":    true,
+	"FETCH":     true,
+	"UNION":     true,
+	"INTERSECT": true,
+	"EXCEPT":    true,
+	"WINDOW":    true,
+	"QUALIFY":   true,
+	"SELECT":    true,
+	"VALUES":    true,
+}
+
+// subqueryKeywords start a subquery in parentheses
+var subqueryKeywords = map[string]bool{
+	"SELECT": true,
+	"WITH":   true,
+	"VALUES": true,
+}
+
+// collectionRefs returns the collections referenced by the query after FROM, JOIN and INTO, including the
+// collections of comma separated joins. References to common table expressions and subqueries aren't collections.
+// It returns a collectionError when a table after FROM, JOIN or INTO can't be parsed, so the query can't be checked.
+func collectionRefs(sql string) ([]collectionRef, error) {
+	var tokens []sqlToken
+	for _, t := range tokenizeSQL(sql) {
+		if t.kind != tokenWhitespace && t.kind != tokenComment {
+			tokens = append(tokens, t)
+		}
+	}
+
+	// the names of common table expressions, which are followed by AS (, and the index of the closing parenthesis
+	// of their definition, as a name only refers to the common table expression after its definition, and inside
+	// its own definition it still refers to the collection
+	ctes := make(map[string]int)
+	for i := 0; i+2 < len(tokens); i++ {
+		if !isIdentifier(tokens[i]) || !strings.EqualFold(tokens[i+1].text, "AS") || tokens[i+2].text != "(" {
+			continue
+		}
+		name, end := identifierName(tokens[i]), closingParen(tokens, i+2)
+		if prev, found := ctes[name]; !found || end < prev {
+			ctes[name] = end
+		}
+	}
+
+	var refs []collectionRef
+	// the function or keyword before each open parenthesis
+	var calls []string
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.text == "(":
+			call := ""
+			if i > 0 && tokens[i-1].kind == tokenWord {
+				call = strings.ToUpper(tokens[i-1].text)
+			}
+			calls = append(calls, call)
+			continue
+		case t.text == ")":
+			if len(calls) > 0 {
+				calls = calls[:len(calls)-1]
+			}
+			continue
+		case t.kind != tokenWord || !tableKeywords[strings.ToUpper(t.text)]:
+			continue
+		case len(calls) > 0 && fromFunctions[calls[len(calls)-1]]:
+			continue
+		case i > 0 && strings.EqualFold(tokens[i-1].text, "DISTINCT"):
+			// IS DISTINCT FROM
+			continue
+		}
+
+		keyword := strings.ToUpper(t.text)
+		tables, err := parseTables(tokens, i+1, keyword == "INTO", ctes)
+		if err != nil {
+			return nil, &collectionError{keyword: keyword}
+		}
+		refs = append(refs, tables...)
+	}
+
+	return refs, nil
+}
+
+// errUnparsedTable is returned by parseTables when a table can't be parsed
+var errUnparsedTable = errors.New("unparsed table")
+
+// parseTables parses the comma separated tables starting at tokens[i], up to the end of the clause. The joined
+// tables and the subqueries are parsed from their own JOIN and FROM, so only the tables before each comma at the
+// depth of the clause are parsed here. After INTO, a name followed by parentheses is the collection and its columns.
+func parseTables(tokens []sqlToken, i int, into bool, ctes map[string]int) ([]collectionRef, error) {
+	var refs []collectionRef
+	for {
+		ref, next, err := parseTable(tokens, i, into, ctes)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref...)
+
+		// skip the alias, join condition and hints, up to the next table or the end of the clause
+		depth := 0
+		for i = next; i < len(tokens); i++ {
+			t := tokens[i]
+			if t.text == "(" {
+				depth++
+				continue
+			}
+			if t.text == ")" {
+				if depth == 0 {
+					return refs, nil
+				}
+				depth--
+				continue
+			}
+			if depth == 0 && (t.text == "," || t.text == ";" ||
+				t.kind == tokenWord && clauseKeywords[strings.ToUpper(t.text)]) {
+				break
+			}
+		}
+		if i >= len(tokens) || tokens[i].text != "," {
+			return refs, nil
+		}
+		i++
+	}
+}
+
+// parseTable parses the table at tokens[i], which is either name or workspace.name, a subquery, UNNEST, or
+// tables in parentheses, optionally after LATERAL. It returns the collections and the index after the table.
+func parseTable(tokens []sqlToken, i int, into bool, ctes map[string]int) ([]collectionRef, int, error) {
+	if i < len(tokens) && tokens[i].kind == tokenWord && strings.EqualFold(tokens[i].text, "LATERAL") {
+		i++
+	}
+	if i >= len(tokens) {
+		return nil, i, errUnparsedTable
+	}
+
+	t := tokens[i]
+	switch {
+	case t.text == "(":
+		end := closingParen(tokens, i)
+		if i+1 < len(tokens) && tokens[i+1].kind == tokenWord && subqueryKeywords[strings.ToUpper(tokens[i+1].text)] {
+			return nil, end + 1, nil
+		}
+		refs, err := parseTables(tokens, i+1, into, ctes)
+		return refs, end + 1, err
+	case t.kind == tokenWord && strings.EqualFold(t.text, "UNNEST"):
+		if i+1 >= len(tokens) || tokens[i+1].text != "(" {
+			return nil, i, errUnparsedTable
+		}
+		return nil, closingParen(tokens, i+1) + 1, nil
+	case !isIdentifier(t):
+		return nil, i, errUnparsedTable
+	}
+
+	ref := collectionRef{workspace: DefaultWorkspace, name: identifierName(t)}
+	next := i + 1
+	qualified := next+1 < len(tokens) && tokens[next].text == "." && isIdentifier(tokens[next+1])
+	if qualified {
+		ref = collectionRef{workspace: ref.name, name: identifierName(tokens[next+1])}
+		next += 2
+	}
+
+	// a function, other than UNNEST, can't be checked
+	if !into && next < len(tokens) && tokens[next].text == "(" {
+		return nil, next, errUnparsedTable
+	}
+	if end, found := ctes[ref.name]; !qualified && found && i > end {
+		return nil, next, nil
+	}
+
+	return []collectionRef{ref}, next, nil
+}
+
+// closingParen returns the index of the parenthesis which closes the one at tokens[i], or the last index
+// if it isn't closed
+func closingParen(tokens []sqlToken, i int) int {
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		if tokens[j].text == "(" {
+			depth++
+		} else if tokens[j].text == ")" {
+			if depth--; depth == 0 {
+				return j
+			}
+		}
+	}
+
+	return len(tokens) - 1
+}
+
+func isIdentifier(t sqlToken) bool {
+	return t.kind == tokenWord || t.kind == tokenIdentifier
+}
+
+// identifierName returns the name of the identifier, without the quotes of a quoted identifier
+func identifierName(t sqlToken) string {
+	if t.kind != tokenIdentifier || len(t.text) < 2 {
+		return t.text
+	}
+	quote := t.text[:1]
+
+	return strings.ReplaceAll(t.text[1:len(t.text)-1], quote+quote, quote)
+}
+
+// collectionRules are the patterns of the collections the datasource can query, like workspace.collection,
+// where * matches any part of a name, e.g. analytics.* or *.events_*
+type collectionRules struct {
+	allowed []string
+	denied  []string
+}
+
+// validCollectionPattern returns an error if the pattern isn't a valid workspace.collection pattern
+func validCollectionPattern(pattern string) error {
+	if strings.Count(pattern, ".") != 1 {
+		return fmt.Errorf("invalid collection pattern %q, it must be workspace.collection", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid collection pattern %q: %w", pattern, err)
+	}
+
+	return nil
+}
+
+// allows returns whether the collection matches an allowed pattern, or there are none, and no denied pattern
+func (r collectionRules) allows(ref collectionRef) bool {
+	matches := func(patterns []string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, ref.String()); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return (len(r.allowed) == 0 || matches(r.allowed)) && !matches(r.denied)
+}
+
+// collectionError is returned for queries which reference a collection the datasource can't query, or which
+// have a table after the keyword that can't be parsed, so it can't be checked
+type collectionError struct {
+	ref     collectionRef
+	keyword string
+}
+
+func (e *collectionError) Error() string {
+	if e.keyword != "" {
+		return fmt.Sprintf("the datasource can't check the collections after %s, so the query isn't allowed", e.keyword)
+	}
+
+	return fmt.Sprintf("the datasource isn't allowed to query the collection %s", e.ref)
+}
+
+// collectionClient is a RockClient which rejects queries that reference collections the rules don't allow
+type collectionClient struct {
+	RockClient
+	rules collectionRules
+}
+
+// withCollectionRules wraps the RockClient so queries can only reference the collections allowed by the rules
+func withCollectionRules(rs RockClient, rules collectionRules) RockClient {
+	if len(rules.allowed) == 0 && len(rules.denied) == 0 {
+		return rs
+	}
+
+	return &collectionClient{RockClient: rs, rules: rules}
+}
+
+// Query executes the query, unless it references a collection which isn't allowed, or one which can't be parsed
+func (c *collectionClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	refs, err := collectionRefs(sql)
+	if err != nil {
+		return openapi.QueryResponse{}, err
+	}
+	for _, ref := range refs {
+		if !c.rules.allows(ref) {
+			return openapi.QueryResponse{}, &collectionError{ref: ref}
+		}
+	}
+
+	return c.RockClient.Query(ctx, sql, options...)
+}
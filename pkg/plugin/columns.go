@@ -0,0 +1,63 @@
+package plugin
+
+import (
+	"sort"
+)
+
+// The special columns Rockset adds to every document
+const (
+	idColumn   = "_id"
+	metaColumn = "_meta"
+)
+
+// orderColumns orders the columns found in the results of queries, where Rockset doesn't set the ColumnFields,
+// such as for SELECT *, in a deterministic order with the event time and id first, followed by the rest in
+// alphabetical order. The _meta column is left out, as it only contains metadata about the document.
+func orderColumns(names []string) []string {
+	ordered := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != metaColumn {
+			ordered = append(ordered, name)
+		}
+	}
+
+	sort.Slice(ordered, func(i, j int) bool {
+		ri, rj := columnRank(ordered[i]), columnRank(ordered[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return ordered[i] < ordered[j]
+	})
+
+	return ordered
+}
+
+// columnRank orders the special columns before all other columns
+func columnRank(name string) int {
+	switch name {
+	case DefaultTimeColumn:
+		return 0
+	case idColumn:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// valueType returns the Rockset type of the value from the JSON decoded results
+func valueType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
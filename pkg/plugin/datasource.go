@@ -8,16 +8,21 @@ import (
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/rockset/rockset-go-client"
 	rockerr "github.com/rockset/rockset-go-client/errors"
 	"github.com/rockset/rockset-go-client/openapi"
 	"github.com/rockset/rockset-go-client/option"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Make sure RocksetDatasource implements required interfaces. This is important to do
@@ -28,13 +33,14 @@ import (
 var (
 	_ backend.QueryDataHandler      = (*RocksetDatasource)(nil)
 	_ backend.CheckHealthHandler    = (*RocksetDatasource)(nil)
+	_ backend.StreamHandler         = (*RocksetDatasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*RocksetDatasource)(nil)
 )
 
 // NewRocksetDatasource creates a new datasource instance.
 func NewRocksetDatasource(_ context.Context, _ backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
 	return &RocksetDatasource{
-		RockFactory,
+		ClientFactory: RockFactory,
 	}, nil
 }
 
@@ -42,6 +48,15 @@ func NewRocksetDatasource(_ context.Context, _ backend.DataSourceInstanceSetting
 // its health and has streaming skills.
 type RocksetDatasource struct {
 	ClientFactory func(...rockset.RockOption) (RockClient, error) `json:"-"`
+
+	// streams holds the streamQuery for each channel path, registered when a streaming query is executed
+	streams sync.Map
+	// cache holds the responses of queries, when caching is turned on in the settings or the query
+	cache resultCache
+	// inflight holds the queries which are executing, so identical queries can share the response
+	inflight queryGroup
+	// incremental holds the frames of the previous refresh of incremental queries
+	incremental incrementalStore
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
@@ -56,23 +71,18 @@ func (d *RocksetDatasource) Dispose() {
 // The QueryDataResponse contains a map of RefID to the response for each query, and each response
 // contains Frames ([]*Frame).
 func (d *RocksetDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
-	apiKey, found := req.PluginContext.DataSourceInstanceSettings.DecryptedSecureJSONData["apiKey"]
-	if !found {
-		return nil, fmt.Errorf("could not locate apiKey")
-	}
-
-	server, err := getServer(req.PluginContext.DataSourceInstanceSettings.JSONData)
-	if err != nil {
-		return nil, fmt.Errorf("could not locate server")
-	}
+	ctx, span := tracing.DefaultTracer().Start(ctx, "RocksetDatasource.QueryData",
+		trace.WithAttributes(attribute.Int("grafana.queries", len(req.Queries))))
+	defer span.End()
 
-	vi, err := getVI(req.PluginContext.DataSourceInstanceSettings.JSONData)
+	settings, err := loadSettings(req.PluginContext.DataSourceInstanceSettings)
 	if err != nil {
-		return nil, fmt.Errorf("could not locate virtual instance id")
+		return nil, err
 	}
+	vi := settings.VI
 
-	rs, err := d.ClientFactory(rockset.WithAPIKey(apiKey), rockset.WithAPIServer(server),
-		rockset.WithCustomHeader("rockset-grafana-backend", "v0.3"))
+	qc := newQueryContext(req, !settings.DisableUserIdentity)
+	rs, err := d.newClient(ctx, settings, qc.headers())
 	if err != nil {
 		id := "unknown"
 		if len(req.Queries) > 0 {
@@ -81,30 +91,56 @@ func (d *RocksetDatasource) QueryData(ctx context.Context, req *backend.QueryDat
 
 		return &backend.QueryDataResponse{
 			Responses: map[string]backend.DataResponse{
-				id: backend.ErrDataResponse(backend.StatusUnknown,
+				id: backend.ErrDataResponseWithSource(backend.StatusUnknown, backend.ErrorSourcePlugin,
 					fmt.Sprintf("could create Rockset datasource: %v", err)),
 			},
 		}, nil
 	}
 
+	uid := req.PluginContext.DataSourceInstanceSettings.UID
+	ql := newQueryLogger(settings)
+
 	// create response struct
 	response := backend.NewQueryDataResponse()
 
 	// loop over queries and execute them individually.
 	log.DefaultLogger.Info("got queries", "count", len(req.Queries))
 	for _, q := range req.Queries {
-		log.DefaultLogger.Info("query", "refId", q.RefID, "JSON", string(q.JSON))
-
-		var res backend.DataResponse
-		switch q.RefID {
-		case "Anno":
-			res = AnnotationsQuery(ctx, rs, vi, q)
-		case "variable-query":
-			res = VariablesQuery(ctx, rs, vi, q)
-		default:
-			res = MetricsQuery(ctx, rs, vi, q)
+		ql.queryJSON(q.RefID, q.JSON)
+
+		queryType := queryTypeOf(q.RefID)
+		qctx, qspan := tracing.DefaultTracer().Start(ctx, "RocksetDatasource.query",
+			trace.WithAttributes(attributeRefID.String(q.RefID), attributeQueryType.String(queryType)))
+		qctx = withMaxRowLimit(qctx, settings.MaxRowLimit)
+		qrs := withMetrics(withQueryContext(rs, qc), queryType, uid)
+		if !settings.StreamResults {
+			qrs = withDeduplication(qrs, &d.inflight, queryType, uid)
+		}
+		qrs = withLogging(withTracing(qrs, q.RefID, queryType), settings)
+
+		res := d.cachedQuery(req, settings, queryType, q, func() backend.DataResponse {
+			switch queryType {
+			case QueryTypeAnnotations:
+				return AnnotationsQuery(qctx, qrs, vi, q)
+			case QueryTypeVariables:
+				return VariablesQuery(qctx, qrs, vi, q)
+			default:
+				return d.incrementalQuery(req, settings, q, func(q backend.DataQuery) backend.DataResponse {
+					return timeOffsetQuery(q, func(q backend.DataQuery) backend.DataResponse {
+						return MetricsQuery(qctx, qrs, vi, q)
+					})
+				})
+			}
+		})
+		if queryType == QueryTypeMetrics {
+			d.registerStream(req.PluginContext, q, res)
 		}
 
+		if res.Error != nil {
+			qspan.SetStatus(codes.Error, res.Error.Error())
+		}
+		qspan.End()
+
 		// save the response in a hashmap based on with RefID as identifier
 		response.Responses[q.RefID] = res
 	}
@@ -112,6 +148,18 @@ func (d *RocksetDatasource) QueryData(ctx context.Context, req *backend.QueryDat
 	return response, nil
 }
 
+// queryTypeOf returns the query type based on the refId the frontend uses for the query
+func queryTypeOf(refID string) string {
+	switch refID {
+	case "Anno":
+		return QueryTypeAnnotations
+	case "variable-query":
+		return QueryTypeVariables
+	default:
+		return QueryTypeMetrics
+	}
+}
+
 // AnnotationsQuery handles annotation queries from grafana
 func AnnotationsQuery(ctx context.Context, rs RockClient, vi string, query backend.DataQuery) (response backend.DataResponse) {
 	defer func() {
@@ -121,36 +169,34 @@ func AnnotationsQuery(ctx context.Context, rs RockClient, vi string, query backe
 
 			response.Error = fmt.Errorf("internal plugin error, please contact Rockset support")
 			response.Status = backend.StatusInternal
+			response.ErrorSource = backend.ErrorSourcePlugin
 		}
 	}()
 
 	var qm AnnotationsQueryModel
 	err := json.Unmarshal(query.JSON, &qm)
 	if err != nil {
-		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("failed to unmarshal query: %v", err.Error()))
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream,
+			fmt.Sprintf("failed to unmarshal query: %v", err.Error()))
 	}
 
 	options := buildQueryOptions(qm, query.TimeRange.From, query.TimeRange.To, vi)
-	log.DefaultLogger.Info("executing annotations query", "SQL", qm.QueryText)
 	qr, err := rs.Query(ctx, qm.QueryText, options...)
 	if err != nil {
 		return errorToResponse(err)
 	}
 	logQueryResponse(qr)
 
-	if len(qr.ColumnFields) == 0 {
-		return backend.ErrDataResponse(backend.StatusValidationFailed,
-			"Query must not use 'SELECT *', instead explicitly specify the columns to return")
-	}
-
 	frame := makeFrame("annotations", qm.QueryText, qr)
-	fields, err := extractWideFields(qm.QueryTimeField, "", "", qr)
+	quality := newDataQuality()
+	ss, err := extractSeries(ctx, qm.QueryTimeField, "", qr, quality)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to extract fields: %v", err)
-		return backend.ErrDataResponse(backend.StatusUnknown, errMsg)
+		return backend.ErrDataResponseWithSource(backend.StatusUnknown, backend.ErrorSourceDownstream, errMsg)
 	}
 
-	frame.Fields = append(frame.Fields, fields...)
+	frame.Fields = append(frame.Fields, ss[0]...)
+	frame.AppendNotices(quality.notices()...)
 	response.Frames = append(response.Frames, frame)
 
 	return response
@@ -165,13 +211,15 @@ func VariablesQuery(ctx context.Context, rs RockClient, vi string, query backend
 
 			response.Error = fmt.Errorf("internal plugin error, please contact Rockset support")
 			response.Status = backend.StatusInternal
+			response.ErrorSource = backend.ErrorSourcePlugin
 		}
 	}()
 
 	var qm VariablesQueryModel
 	err := json.Unmarshal(query.JSON, &qm)
 	if err != nil {
-		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("failed to unmarshal query: %v", err.Error()))
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream,
+			fmt.Sprintf("failed to unmarshal query: %v", err.Error()))
 	}
 
 	var options []option.QueryOption
@@ -179,7 +227,6 @@ func VariablesQuery(ctx context.Context, rs RockClient, vi string, query backend
 		options = append(options, option.WithVirtualInstance(vi))
 	}
 
-	log.DefaultLogger.Info("executing variables query", "SQL", qm.QueryText)
 	qr, err := rs.Query(ctx, qm.QueryText, options...)
 	if err != nil {
 		return errorToResponse(err)
@@ -199,132 +246,189 @@ func VariablesQuery(ctx context.Context, rs RockClient, vi string, query backend
 }
 
 // MetricsQuery executes a single query and returns the result
-func MetricsQuery(ctx context.Context, rs RockClient, vi string, query backend.DataQuery) backend.DataResponse {
+func MetricsQuery(ctx context.Context, rs RockClient, vi string, query backend.DataQuery) (response backend.DataResponse) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.DefaultLogger.Error("recovered from panic", "error", r)
 			log.DefaultLogger.Error(string(debug.Stack()))
+
+			response.Error = fmt.Errorf("internal plugin error, please contact Rockset support")
+			response.Status = backend.StatusInternal
+			response.ErrorSource = backend.ErrorSourcePlugin
 		}
 	}()
 
-	var response backend.DataResponse
-
 	// Unmarshal the request JSON into our MetricsQueryModel.
 	var qm MetricsQueryModel
 	err := json.Unmarshal(query.JSON, &qm)
 	if err != nil {
-		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("failed to unmarshal query: %v", err.Error()))
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream,
+			fmt.Sprintf("failed to unmarshal query: %v", err.Error()))
+	}
+	if !validFill(qm.Fill) {
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream,
+			fmt.Sprintf("unknown fill %q", qm.Fill))
+	}
+	if !validDownsample(qm.Downsample) {
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream,
+			fmt.Sprintf("unknown downsampling %q", qm.Downsample))
 	}
 
-	options := buildQueryOptions(qm, query.TimeRange.From, query.TimeRange.To, vi)
-	log.DefaultLogger.Info("executing metrics query", "SQL", qm.QueryText)
+	// the rows are appended to the series as they are decoded, when the client streams the response
+	quality := newDataQuality()
+	sb := newSeriesBuilder(qm.QueryTimeField, qm.QueryLabelColumn, quality)
+	rows := &rowStream{appendRow: sb.appendRow}
 
-	qr, err := rs.Query(ctx, qm.QueryText, options...)
+	interval, err := chunkInterval(qm.ChunkInterval)
 	if err != nil {
-		return errorToResponse(err)
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
+	}
+	chunks, err := splitTimeRange(query.TimeRange, interval)
+	if err != nil {
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
 	}
-	logQueryResponse(qr)
 
-	if len(qr.Results) == 0 {
-		return backend.ErrDataResponse(backend.StatusValidationFailed, "Query returned no rows")
+	options := buildQueryOptions(qm, query.TimeRange.From, query.TimeRange.To, vi)
+	var qr openapi.QueryResponse
+	var chunkNotices []data.Notice
+	if len(chunks) > 1 {
+		qr, chunkNotices, err = queryChunks(ctx, rs, qm, vi, chunks)
+	} else {
+		qr, err = rs.Query(withRowStream(ctx, rows), qm.QueryText, options...)
 	}
-	// we don't allow SELECT *, as it doesn't set the ColumnFields, but we could calculate that here
-	if len(qr.ColumnFields) == 0 {
-		return backend.ErrDataResponse(backend.StatusValidationFailed,
-			"Query must not use 'SELECT *', instead explicitly specify the columns to return")
+	if rows.err != nil {
+		errMsg := fmt.Sprintf("failed to extract fields: %v", rows.err)
+		return backend.ErrDataResponseWithSource(backend.StatusUnknown, backend.ErrorSourceDownstream, errMsg)
 	}
+	if err != nil {
+		return errorToResponse(err)
+	}
+	logQueryResponse(qr)
 
-	labels, err := extractLabelValues(qm.QueryLabelColumn, qr.Results)
+	ss, err := sb.extract(ctx, rows, qr)
 	if err != nil {
-		errMsg := fmt.Sprintf("label generation error: %s", err.Error())
-		log.DefaultLogger.Error(errMsg)
-		return backend.ErrDataResponse(backend.StatusInternal, errMsg)
+		errMsg := fmt.Sprintf("failed to extract fields: %v", err)
+		return backend.ErrDataResponseWithSource(backend.StatusUnknown, backend.ErrorSourceDownstream, errMsg)
 	}
-	log.DefaultLogger.Debug("extracted labels", "labels", labels)
+	ss, fillNotices := fillSeries(ss, qm.Fill, fillInterval(qm, query), query.TimeRange)
+	ss, downsampled := downsampleSeries(ss, qm.Downsample, int(qm.MaxDataPoints))
 
-	for _, label := range labels {
-		log.DefaultLogger.Info("processing label", "label", label)
-		frame := makeFrame("metrics", qm.QueryText, qr)
+	if rows.rows == 0 {
+		// an empty frame lets alert rules and panels treat the result as no data, rather than as an error
+		response.Frames = append(response.Frames,
+			emptyFrame("metrics", qm.QueryText, qm.QueryTimeField, qm.QueryLabelColumn, qr))
+	} else {
+		for _, fields := range ss {
+			frame := makeFrame("metrics", qm.QueryText, qr)
+			frame.Fields = append(frame.Fields, fields...)
+			response.Frames = append(response.Frames, frame)
+		}
+	}
 
-		fields, err := extractWideFields(qm.QueryTimeField, qm.QueryLabelColumn, label, qr)
-		if err != nil {
-			errMsg := fmt.Sprintf("failed to extract fields for label %s: %v", label, err)
-			return backend.ErrDataResponse(backend.StatusUnknown, errMsg)
+	if len(response.Frames) > 0 {
+		if rows.truncated {
+			response.Frames[0].AppendNotices(truncatedNotice(rows.rows, rows.limit))
+		}
+		if downsampled > 0 {
+			response.Frames[0].AppendNotices(downsampleNotice(downsampled, len(ss), int(qm.MaxDataPoints), qm.Downsample))
 		}
+		response.Frames[0].AppendNotices(chunkNotices...)
+		response.Frames[0].AppendNotices(fillNotices...)
+		// the problems are counted across all series, so they are only reported once
+		response.Frames[0].AppendNotices(quality.notices()...)
+	}
+
+	return attachQueryPlan(ctx, rs, qm, options, qr, response)
+}
 
-		log.DefaultLogger.Info("adding frame", "fields", len(fields), "label", label)
-		frame.Fields = append(frame.Fields, fields...)
-		response.Frames = append(response.Frames, frame)
+// attachQueryPlan adds the query plan to the first frame of the response, if the query asks for it
+func attachQueryPlan(ctx context.Context, rs RockClient, qm MetricsQueryModel, options []option.QueryOption,
+	qr openapi.QueryResponse, response backend.DataResponse) backend.DataResponse {
+	if qm.QueryPlan == "" || len(response.Frames) == 0 {
+		return response
 	}
 
+	meta, frames, notices := queryPlan(ctx, rs, qm.QueryPlan, qm.QueryText, options, qr)
+	response.Frames[0].Meta.Custom = meta
+	response.Frames[0].AppendNotices(notices...)
+	response.Frames = append(response.Frames, frames...)
+
 	return response
 }
 
 func buildQueryOptions[T queryModel](qm T, from, to time.Time, vi string) []option.QueryOption {
 	var options []option.QueryOption
-	var opts []any
-
-	log.DefaultLogger.Info("query parameters",
-		"interval", qm.GetIntervalMs(),
-		"max data points", qm.GetMaxDataPoints(),
-		"from", from,
-		"to", to,
-		"duration", to.Sub(from).String())
 
 	if qm.GetIntervalMs() > 0 {
-		opts = append(opts, ":interval", qm.GetIntervalMs())
 		options = append(options, option.WithParameter("interval", "int", strconv.FormatUint(qm.GetIntervalMs(), 10)))
 	}
 
 	// set defaults and trim ":" from the start/stop
 	start := strings.TrimPrefix(qm.GetQueryParamStart(), ":")
 	if start != "" {
-		opts = append(opts, ":"+start, from.UTC().Format(time.RFC3339)) // ":" just for logging
 		options = append(options, option.WithParameter(start, "timestamp", from.UTC().Format(time.RFC3339)))
 	}
 
 	stop := strings.TrimPrefix(qm.GetQueryParamStop(), ":")
 	if stop != "" {
-		opts = append(opts, ":"+stop, to.UTC().Format(time.RFC3339)) // ":" just for logging
 		options = append(options, option.WithParameter(stop, "timestamp", to.UTC().Format(time.RFC3339)))
 	}
 
-	if qm.GetMaxDataPoints() > 0 {
-		opts = append(opts, "row limit", qm.GetMaxDataPoints())
-		options = append(options, option.WithDefaultRowLimit(qm.GetMaxDataPoints()))
+	if qm.GetRowLimit() > 0 {
+		options = append(options, option.WithDefaultRowLimit(qm.GetRowLimit()))
 	}
 
 	if vi != "" {
-		opts = append(opts, "vi", vi)
 		options = append(options, option.WithVirtualInstance(vi))
 	}
 
-	log.DefaultLogger.Info("query options", opts...)
-
 	return options
 }
 
+// applyQueryOptions returns the request the Rockset client sends for the query, with the options applied
+func applyQueryOptions(sql string, options []option.QueryOption) option.QueryOptions {
+	request := option.QueryOptions{
+		QueryRequest: openapi.NewQueryRequest(openapi.QueryRequestSql{Query: sql, Parameters: []openapi.QueryParameter{}}),
+	}
+	for _, o := range options {
+		o(&request)
+	}
+
+	return request
+}
+
+// errorToResponse converts the error to a response with the Grafana status and source of the error, and when
+// Rockset reports where in the SQL the error is, the error message shows that part of the SQL
 func errorToResponse(err error) backend.DataResponse {
 	var re rockerr.Error
-	var errMessage string
-	statusCode := backend.StatusUnknown
-	if errors.As(err, &re) {
-		statusCode = backend.Status(re.StatusCode)
+	var errMessage, snippet string
+	statusCode, source := errorStatus(err)
+	if errors.As(err, &re) && re.ErrorModel != nil {
 		errMessage = fmt.Sprintf("There was a problem executing your query: Error ID [%s] - Query ID [%s]\nLine: %d Column: %d\n%s",
 			re.GetErrorId(), re.GetQueryId(), re.GetLine(), re.GetColumn(), re.Error())
+
+		var se *sqlError
+		if errors.As(err, &se) {
+			snippet = sqlSnippet(se.sql, int(re.GetLine()), int(re.GetColumn()))
+		}
 	} else {
 		errMessage = fmt.Sprintf("There was a problem executing your query:\n%s", err.Error())
 	}
 
-	log.DefaultLogger.Error("query error", "error", errMessage)
-	return backend.ErrDataResponse(statusCode, errMessage)
+	// the snippet isn't logged, as the SQL is logged according to the logging settings
+	log.DefaultLogger.Error("query error", "error", errMessage, "status", statusCode, "source", source)
+	if snippet != "" {
+		errMessage += "\n\n" + snippet
+	}
+
+	return backend.ErrDataResponseWithSource(statusCode, source, errMessage)
 }
 
 func logQueryResponse(qr openapi.QueryResponse) {
 	page := qr.GetPagination()
 	log.DefaultLogger.Info("query response",
-		"elapsedTime", qr.Stats.ElapsedTimeMs,
-		"docs", len(qr.Results),
+		"elapsedTime", qr.Stats.GetElapsedTimeMs(),
+		"docs", resultRows(qr),
 		"errors", qr.GetQueryErrors(),
 		"warnings", strings.Join(qr.GetWarnings(), ", "),
 		"queryID", qr.GetQueryId(),
@@ -348,7 +452,7 @@ func makeFrame(name, query string, qr openapi.QueryResponse) *data.Frame {
 			},
 			{
 				FieldConfig: data.FieldConfig{DisplayName: "documents in the result"},
-				Value:       float64(len(qr.Results)),
+				Value:       float64(resultRows(qr)),
 			},
 		},
 	}
@@ -383,6 +487,51 @@ func makeFrame(name, query string, qr openapi.QueryResponse) *data.Frame {
 	return frame
 }
 
+// emptyFrame returns a frame without rows, where the fields are typed using the column types reported by Rockset
+func emptyFrame(name, query, timeColumn, labelColumn string, qr openapi.QueryResponse) *data.Frame {
+	frame := makeFrame(name, query, qr)
+
+	if timeColumn == "" {
+		timeColumn = DefaultTimeColumn
+	}
+
+	for _, c := range qr.ColumnFields {
+		switch c.Name {
+		case labelColumn:
+			continue
+		case timeColumn:
+			frame.Fields = append(frame.Fields, data.NewField("time", nil, []time.Time{}))
+		default:
+			if values := emptyValues(c.Type); values != nil {
+				frame.Fields = append(frame.Fields, data.NewField(c.Name, nil, values))
+			}
+		}
+	}
+
+	frame.AppendNotices(data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     "Query returned no rows",
+	})
+
+	return frame
+}
+
+// emptyValues returns an empty slice of the type extractWideFields uses for the Rockset type, or nil for
+// types it skips
+func emptyValues(rocksetType string) interface{} {
+	switch strings.ToLower(rocksetType) {
+	case "bool":
+		return []*bool{}
+	case "int", "float":
+		// JSON numbers are decoded as float64
+		return []*float64{}
+	case "string", "date", "datetime", "time", "timestamp":
+		return []*string{}
+	default:
+		return nil
+	}
+}
+
 func extractVariableField(qr []map[string]interface{}) ([]*data.Field, error) {
 	if len(qr) == 0 {
 		return nil, fmt.Errorf("got empty query response")
@@ -408,240 +557,36 @@ func extractVariableField(qr []map[string]interface{}) ([]*data.Field, error) {
 	return []*data.Field{field}, nil
 }
 
-func firstValueInColumn(name string, qr []map[string]interface{}) interface{} {
-	for _, row := range qr {
-		if v, found := row[name]; found && v != nil {
-			return v
-		}
-	}
-
-	return nil
-}
-
 const DefaultTimeColumn = "_event_time"
 
-// extracts fields in wide format
-// https://grafana.com/developers/plugin-tools/introduction/data-frames#wide-format
-func extractWideFields(timeColumn, labelColumn, label string, qr openapi.QueryResponse) ([]*data.Field, error) {
-	var fields []*data.Field
-
-	// the annotation query doesn't set the time column, unless changed,
-	if timeColumn == "" {
-		timeColumn = DefaultTimeColumn
-	}
-
-	// iterate over the columns, extracting each into a field for the frame
-	for i, c := range qr.ColumnFields {
-		// skip label column
-		if c.Name == labelColumn {
-			log.DefaultLogger.Debug("skipping column", "i", i, "name", c.Name)
-			continue
-		}
-		if c.Name == timeColumn {
-			times, err := extractTimeColumn(timeColumn, label, labelColumn, qr.Results)
-			if err != nil {
-				return nil, err
-			}
-			fields = append(fields, data.NewField("time", nil, times))
-			continue
-		}
-		log.DefaultLogger.Debug("processing column", "i", i, "name", c.Name, "label", label)
-
-		// get the first value of the column and assume it is what every value will be the same type
-		t := firstValueInColumn(c.Name, qr.Results)
-
-		// extract the values from the column
-		switch t.(type) {
-		case bool:
-			fields = append(fields, data.NewField(c.Name, data.Labels{labelColumn: label},
-				extractColumnValues[bool](c.Name, label, labelColumn, qr.Results)))
-		case string:
-			fields = append(fields, data.NewField(c.Name, data.Labels{labelColumn: label},
-				extractColumnValues[string](c.Name, label, labelColumn, qr.Results)))
-		case float64:
-			fields = append(fields, data.NewField(c.Name, data.Labels{labelColumn: label},
-				extractColumnValues[float64](c.Name, label, labelColumn, qr.Results)))
-		default:
-			log.DefaultLogger.Error("unknown type", "type", fmt.Sprintf("%T", t), "value", t)
-		}
-	}
-
-	return fields, nil
-}
-
-func extractTimeColumn(name, label, labelColumn string, qr []map[string]interface{}) ([]time.Time, error) {
-	var times []time.Time
-
-	for _, row := range qr {
-		if labelColumn != "" {
-			if l, found := row[labelColumn]; found && l != label {
-				log.DefaultLogger.Debug("skipping column", "name", name)
-				continue
-			}
-		}
-
-		value, found := row[name]
-		if !found {
-			return times, fmt.Errorf("time column not found: %s", name)
-		}
-
-		switch value.(type) {
-		case string:
-			t, err := time.Parse(time.RFC3339Nano, value.(string))
-			if err != nil {
-				return nil, fmt.Errorf("failed to convert %s to time: %w", value, err)
-			}
-			times = append(times, t)
-		default:
-			return nil, fmt.Errorf("column %s is of type '%T', not the expected type 'string'", name, value)
-		}
-	}
-
-	return times, nil
-}
-
-func extractColumnValues[T any](name, label, labelColumn string, qr []map[string]interface{}) []*T {
-	var column []*T
-	for i, row := range qr {
-		if labelColumn != "" {
-			if l, found := row[labelColumn]; found && l != label {
-				// skip rows which doesn't match the label
-				log.DefaultLogger.Debug("skipping row due to missing label", "want", label, "is", l)
-				continue
-			}
-		}
-
-		value, found := row[name]
-		if !found {
-			log.DefaultLogger.Error("column not found", "column", name, "i", i)
-			column = append(column, nil)
-			continue
-		}
-
-		switch value.(type) {
-		case T:
-			v := value.(T)
-			column = append(column, &v)
-		default:
-			log.DefaultLogger.Error("column is not of type",
-				"column", name, "i", i, "type", fmt.Sprintf("%T", value), "value", value)
-			column = append(column, nil)
-		}
-	}
-
-	return column
-}
-
-// extract the set of label values from the label column
-func extractLabelValues(labelColumn string, results []map[string]interface{}) ([]string, error) {
-	labels := make([]string, 0)
-	seen := make(map[string]struct{})
-
-	// if there isn't any label column specified, add an empty string, so we can use it as a special case
-	if labelColumn == "" {
-		return []string{""}, nil
-	}
-
-	for _, m := range results {
-		label, found := m[labelColumn]
-		if !found {
-			log.DefaultLogger.Debug("could not lookup label", "column", labelColumn)
-			continue
-		}
-		l, ok := label.(string)
-		if !ok {
-			log.DefaultLogger.Error("could not cast label column value to string", "label", label)
-			continue
-		}
-
-		if _, found = seen[l]; !found {
-			labels = append(labels, l)
-			seen[l] = struct{}{}
-		}
+// newClient creates a Rockset client for executing queries, which propagates the trace context of ctx
+// and sends the additional headers with each request
+func (d *RocksetDatasource) newClient(ctx context.Context, s Settings, headers map[string]string) (RockClient, error) {
+	options := []rockset.RockOption{
+		rockset.WithAPIKey(s.APIKey),
+		rockset.WithAPIServer(s.Server),
+		rockset.WithCustomHeader("rockset-grafana-backend", "v0.3"),
 	}
-
-	if len(labels) == 0 {
-		log.DefaultLogger.Warn("label column doesn't contain any string values", "column", labelColumn)
-		return []string{""}, nil
+	for k, v := range traceHeaders(ctx) {
+		options = append(options, rockset.WithCustomHeader(k, v))
 	}
-
-	return labels, nil
-}
-
-// CheckHealth handles health checks sent from Grafana to the plugin.
-// The main use case for these health checks is the test button on the
-// datasource configuration page which allows users to verify that
-// a datasource is working as expected.
-func (d *RocksetDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	log.DefaultLogger.Debug("CheckHealth called")
-
-	apiKey, found := req.PluginContext.DataSourceInstanceSettings.DecryptedSecureJSONData["apiKey"]
-	if !found {
-		return healthError("failed to get api key"), nil
+	for k, v := range headers {
+		options = append(options, rockset.WithCustomHeader(k, v))
 	}
 
-	server, err := getServer(req.PluginContext.DataSourceInstanceSettings.JSONData)
+	rs, err := d.ClientFactory(options...)
 	if err != nil {
-		return healthError("unable to unmarshal json"), nil
+		return nil, err
 	}
 
-	rs, err := d.ClientFactory(rockset.WithAPIKey(apiKey), rockset.WithAPIServer(server))
-	rockset.NewClient(rockset.WithAPIKey(apiKey), rockset.WithAPIServer(server))
-	if err != nil {
-		return healthError("failed to create Rockset client: %s", err.Error()), nil
+	if s.StreamResults {
+		rs = withStreaming(rs, s.MaxResponseBytes)
 	}
 
-	// This call requires the GET_ORG_GLOBAL permission, which we can't rely on being granted,
-	// so perhaps we should use `SELECT 1` instead? At least the error message from the call
-	// highlight that the permission is missing.
-
-	// validate that we can connect by getting the org info
-	org, err := rs.GetOrganization(ctx)
-	if err != nil {
-		log.DefaultLogger.Error("CheckHealth failed", "err", err.Error())
-		return healthError("failed get connect to Rockset: %s", err.Error()), nil
-	}
-	log.DefaultLogger.Debug("CheckHealth successful", "org", org.GetId())
-
-	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: fmt.Sprintf("Rockset datasource is working, connected to %s", org.GetId()),
-	}, nil
-}
-
-func getServer(data []byte) (string, error) {
-	var conf struct {
-		Server string `json:"server"`
-	}
-
-	if err := json.Unmarshal(data, &conf); err != nil {
-		return "", fmt.Errorf("failed to unmarshal server json: %w", err)
+	rs = withCollectionRules(withSQLErrors(withRowLimit(rs, s.MaxRowLimit)), s.collectionRules())
+	if !s.AllowWrites {
+		rs = withReadOnly(rs)
 	}
 
-	return conf.Server, nil
-}
-
-func getVI(data []byte) (string, error) {
-	var conf struct {
-		VI string `json:"vi"`
-	}
-
-	if err := json.Unmarshal(data, &conf); err != nil {
-		return "", fmt.Errorf("failed to unmarshal server json: %w", err)
-	}
-
-	return conf.VI, nil
-}
-
-func healthError(msg string, args ...string) *backend.CheckHealthResult {
-	var message string
-	if len(args) > 0 {
-		message = fmt.Sprintf(msg, args)
-	} else {
-		message = msg
-	}
-	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusError,
-		Message: message,
-	}
+	return rs, nil
 }
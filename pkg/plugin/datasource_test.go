@@ -3,14 +3,29 @@ package plugin_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rockset/rockset-go-client"
+	rockerr "github.com/rockset/rockset-go-client/errors"
 	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	"github.com/rockset/rockset-grafana-backend/pkg/plugin"
 	"github.com/rockset/rockset-grafana-backend/pkg/plugin/fake"
@@ -107,7 +122,1753 @@ func TestQueryData(t *testing.T) {
 	assert.Equal(t, f, 3.333)
 }
 
-func marshal(t *testing.T, v interface{}) []byte {
+func TestQueryDataWithQueryPlan(t *testing.T) {
+	qr := openapi.QueryResponse{
+		QueryId:      openapi.PtrString("query-id"),
+		Results:      prepareTestData(t, []testType{{Time: "2024-01-23T19:25:17.000000-08:00", V1: 1.111}}),
+		ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+		Stats:        &openapi.QueryResponseStats{},
+	}
+	explain := openapi.QueryResponse{
+		Results: []map[string]interface{}{
+			{"EXPLAIN": "select $1 AS v1\n  index filter on commons.test\n"},
+		},
+		ColumnFields: []openapi.QueryFieldType{{Name: "EXPLAIN"}},
+	}
+
+	rc := fake.FakeRockClient{}
+	rc.QueryReturnsOnCall(0, qr, nil)
+	rc.QueryReturnsOnCall(1, explain, nil)
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{
+		QueryModel: plugin.QueryModel{
+			QueryTimeField: "time",
+			QueryText:      "SELECT time, v1 FROM commons.test",
+			QueryPlan:      plugin.QueryPlanGraph,
+		},
+	}
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: fakePluginContext(),
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, rc.QueryCallCount())
+	_, sql, _ := rc.QueryArgsForCall(1)
+	assert.Equal(t, "EXPLAIN SELECT time, v1 FROM commons.test", sql)
+
+	frames := resp.Responses["A"].Frames
+	require.Len(t, frames, 3, "frames")
+
+	meta, ok := frames[0].Meta.Custom.(plugin.QueryPlanMeta)
+	require.True(t, ok)
+	assert.Equal(t, "query-id", meta.QueryID)
+	assert.Equal(t, []string{"select $1 AS v1", "  index filter on commons.test"}, meta.Plan)
+
+	nodes, edges := frames[1], frames[2]
+	assert.Equal(t, "nodes", nodes.Name)
+	assert.Equal(t, 2, nodes.Rows())
+	assert.Equal(t, "index", nodes.Fields[1].At(1))
+	require.Equal(t, 1, edges.Rows())
+	assert.Equal(t, "1", edges.Fields[1].At(0))
+	assert.Equal(t, "0", edges.Fields[2].At(0))
+}
+
+func TestQueryMetrics(t *testing.T) {
+	qr := openapi.QueryResponse{
+		Results:      prepareTestData(t, []testType{{Time: "2024-01-23T19:25:17.000000-08:00", V1: 1.111}}),
+		ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+		Stats:        &openapi.QueryResponseStats{ElapsedTimeMs: openapi.PtrInt64(12)},
+	}
+
+	rc := fake.FakeRockClient{}
+	rc.QueryReturns(qr, nil)
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time"}}
+	pCtx := fakePluginContext()
+	pCtx.DataSourceInstanceSettings.UID = "metrics-test"
+	_, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pCtx,
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	})
+	require.NoError(t, err)
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	var queries, rows float64
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["datasource_uid"] != "metrics-test" || labels["query_type"] != plugin.QueryTypeMetrics {
+				continue
+			}
+			switch family.GetName() {
+			case "grafana_plugin_rockset_queries_total":
+				queries = m.GetCounter().GetValue()
+			case "grafana_plugin_rockset_query_rows":
+				rows = m.GetHistogram().GetSampleSum()
+			}
+		}
+	}
+	assert.Equal(t, 1.0, queries)
+	assert.Equal(t, 1.0, rows)
+}
+
+func TestQueryTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracing.InitDefaultTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test"))
+	t.Cleanup(func() { tracing.InitDefaultTracer(otel.Tracer("")) })
+
+	qr := openapi.QueryResponse{
+		QueryId:      openapi.PtrString("query-id"),
+		Results:      prepareTestData(t, []testType{{Time: "2024-01-23T19:25:17.000000-08:00", V1: 1.111}}),
+		ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+		Stats:        &openapi.QueryResponseStats{},
+	}
+
+	rc := fake.FakeRockClient{}
+	rc.QueryReturns(qr, nil)
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time"}}
+	_, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: fakePluginContext(),
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	})
+	require.NoError(t, err)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "RocksetDatasource.QueryData")
+	require.Contains(t, spans, "RocksetDatasource.query")
+	require.Contains(t, spans, "extractSeries")
+	require.Contains(t, spans, "rockset.Query")
+
+	query := spans["rockset.Query"]
+	assert.Equal(t, spans["RocksetDatasource.query"].SpanContext().SpanID(), query.Parent().SpanID())
+	assert.Contains(t, query.Attributes(), attribute.String("grafana.ref_id", "A"))
+	assert.Contains(t, query.Attributes(), attribute.String("rockset.query_id", "query-id"))
+	assert.Contains(t, query.Attributes(), attribute.Int("rockset.rows", 1))
+}
+
+func TestQueryContext(t *testing.T) {
+	qr := openapi.QueryResponse{
+		Results:      prepareTestData(t, []testType{{Time: "2024-01-23T19:25:17.000000-08:00", V1: 1.111}}),
+		ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+		Stats:        &openapi.QueryResponseStats{},
+	}
+
+	rc := fake.FakeRockClient{}
+	rc.QueryReturns(qr, nil)
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1"}}
+	pCtx := fakePluginContext()
+	pCtx.User = &backend.User{Login: "jane*/"}
+	req := &backend.QueryDataRequest{
+		PluginContext: pCtx,
+		Headers:       map[string]string{"http_X-Dashboard-Uid": "dash", "http_X-Panel-Id": "4"},
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	}
+
+	_, err := ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+	_, sql, _ := rc.QueryArgsForCall(0)
+	assert.Equal(t, "SELECT 1\n/* grafana {\"dashboardUid\":\"dash\",\"panelId\":\"4\",\"user\":\"jane*\\/\"} */", sql)
+
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"server":"api.usw2a1.rockset.com","disableUserIdentity":true}`)
+	_, err = ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+	_, sql, _ = rc.QueryArgsForCall(1)
+	assert.Equal(t, "SELECT 1\n/* grafana {\"dashboardUid\":\"dash\",\"panelId\":\"4\"} */", sql)
+}
+
+func TestQueryNoRows(t *testing.T) {
+	qr := openapi.QueryResponse{
+		Results: []map[string]interface{}{},
+		ColumnFields: []openapi.QueryFieldType{
+			{Name: "time", Type: "timestamp"},
+			{Name: "host", Type: "string"},
+			{Name: "v1", Type: "float"},
+			{Name: "up", Type: "bool"},
+			{Name: "tags", Type: "array"},
+		},
+		Stats: &openapi.QueryResponseStats{},
+	}
+
+	rc := fake.FakeRockClient{}
+	rc.QueryReturns(qr, nil)
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1"},
+		QueryLabelColumn: "host"}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: fakePluginContext(),
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	})
+	require.NoError(t, err)
+
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+
+	frame := res.Frames[0]
+	rows, err := frame.RowLen()
+	require.NoError(t, err)
+	assert.Equal(t, 0, rows)
+	require.Len(t, frame.Fields, 3)
+	assert.Equal(t, data.FieldTypeTime, frame.Fields[0].Type())
+	assert.Equal(t, "v1", frame.Fields[1].Name)
+	assert.Equal(t, data.FieldTypeNullableFloat64, frame.Fields[1].Type())
+	assert.Equal(t, data.FieldTypeNullableBool, frame.Fields[2].Type())
+	require.Len(t, frame.Meta.Notices, 1)
+	assert.Equal(t, data.NoticeSeverityInfo, frame.Meta.Notices[0].Severity)
+}
+
+func TestQuerySelectStar(t *testing.T) {
+	qr := openapi.QueryResponse{
+		Results: []map[string]interface{}{
+			{"_event_time": "2024-01-23T19:25:17.000000-08:00", "_id": "a", "_meta": map[string]interface{}{},
+				"v2": float64(1), "host": "h1"},
+			{"_event_time": "2024-01-23T19:26:17.000000-08:00", "_id": "b", "_meta": map[string]interface{}{},
+				"v1": float64(2), "up": true},
+		},
+		Stats: &openapi.QueryResponseStats{},
+	}
+
+	rc := fake.FakeRockClient{}
+	rc.QueryReturns(qr, nil)
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryText: "SELECT * FROM foo"}}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: fakePluginContext(),
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	})
+	require.NoError(t, err)
+
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+
+	var names []string
+	for _, f := range res.Frames[0].Fields {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"time", "_id", "host", "up", "v1", "v2"}, names)
+	rows, err := res.Frames[0].RowLen()
+	require.NoError(t, err)
+	assert.Equal(t, 2, rows)
+}
+
+func TestQueryDataQualityNotices(t *testing.T) {
+	qr := openapi.QueryResponse{
+		Results: []map[string]interface{}{
+			{"time": "2024-01-23T19:25:17.000000-08:00", "host": "h1", "latency": float64(1), "tags": []interface{}{"a"}},
+			{"time": "2024-01-23T19:26:17.000000-08:00", "host": "h1", "latency": "slow", "tags": []interface{}{"b"}},
+			{"time": "2024-01-23T19:27:17.000000-08:00", "host": "h2", "tags": []interface{}{"c"}},
+			{"time": "2024-01-23T19:28:17.000000-08:00", "host": float64(3), "latency": float64(2)},
+		},
+		ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "host"}, {Name: "latency"}, {Name: "tags"}},
+		Stats:        &openapi.QueryResponseStats{},
+	}
+
+	rc := fake.FakeRockClient{}
+	rc.QueryReturns(qr, nil)
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1"},
+		QueryLabelColumn: "host"}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: fakePluginContext(),
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	})
+	require.NoError(t, err)
+
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 2)
+
+	var notices []string
+	for _, n := range res.Frames[0].Meta.Notices {
+		notices = append(notices, n.Text)
+	}
+	assert.Equal(t, []string{
+		"label column host: 1 of 4 values were numbers and the rows were dropped",
+		"column latency: 1 of 3 values were strings and were dropped",
+		"column latency: missing in 1 of 3 rows",
+		"column tags: values are arrays, which aren't supported, so the column was dropped",
+	}, notices)
+	assert.Empty(t, res.Frames[1].Meta.Notices)
+}
+
+func TestQueryStreamResults(t *testing.T) {
+	results := make([]map[string]interface{}, 1000)
+	for i := range results {
+		results[i] = map[string]interface{}{
+			"time": time.Date(2024, 1, 23, 0, 0, i, 0, time.UTC).Format(time.RFC3339Nano),
+			"host": fmt.Sprintf("host-%d", i%2),
+			"v1":   float64(i),
+		}
+	}
+	body := marshal(t, openapi.QueryResponse{
+		QueryId:              openapi.PtrString("query-id"),
+		Results:              results,
+		ResultsTotalDocCount: openapi.PtrInt64(int64(len(results))),
+		ColumnFields:         []openapi.QueryFieldType{{Name: "time"}, {Name: "host"}, {Name: "v1"}},
+		Stats:                &openapi.QueryResponseStats{ElapsedTimeMs: openapi.PtrInt64(12)},
+	})
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/orgs/self/virtualinstances/vi/queries", r.URL.Path)
+		assert.Equal(t, "apikey foobar", r.Header.Get("Authorization"))
+
+		var req openapi.QueryRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "SELECT 1", req.Sql.Query)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		maxBytes    int64
+		maxRowLimit int32
+		truncated   bool
+		elapsed     float64
+	}{
+		{name: "all rows", elapsed: 12},
+		// the rows after the limit are skipped, but the stats after the results are still read
+		{name: "truncated", maxBytes: 8192, truncated: true, elapsed: 12},
+		// the rows after the limit are dropped while decoding, but the rest of the response is read
+		{name: "row limit", maxRowLimit: 100, elapsed: 12},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ds := plugin.RocksetDatasource{
+				ClientFactory: func(options ...rockset.RockOption) (plugin.RockClient, error) {
+					return plugin.RockFactory(append(options, rockset.WithHTTPClient(srv.Client()))...)
+				},
+			}
+
+			pc := fakePluginContext()
+			pc.DataSourceInstanceSettings.JSONData = marshal(t, map[string]interface{}{
+				"server": srv.URL, "vi": "vi", "streamResults": true, "maxResponseBytes": tc.maxBytes,
+				"maxRowLimit": tc.maxRowLimit,
+			})
+			qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1"},
+				QueryLabelColumn: "host"}
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: pc,
+				Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+			})
+			require.NoError(t, err)
+
+			res := resp.Responses["A"]
+			require.NoError(t, res.Error)
+			require.Len(t, res.Frames, 2)
+
+			rows := 0
+			for _, frame := range res.Frames {
+				rows += frame.Rows()
+				assert.Equal(t, []string{"time", "v1"}, []string{frame.Fields[0].Name, frame.Fields[1].Name})
+			}
+			assert.Equal(t, tc.elapsed, res.Frames[0].Meta.Stats[0].Value)
+			assert.Equal(t, float64(rows), res.Frames[0].Meta.Stats[2].Value)
+
+			var notices []string
+			for _, n := range res.Frames[0].Meta.Notices {
+				notices = append(notices, n.Text)
+			}
+			if tc.maxRowLimit > 0 {
+				assert.Equal(t, int(tc.maxRowLimit), rows)
+				assert.Equal(t, []string{"the result was truncated to the row limit of 100 rows"}, notices)
+				return
+			}
+			if !tc.truncated {
+				assert.Equal(t, len(results), rows)
+				assert.Empty(t, notices)
+				return
+			}
+			// the decoder reads ahead, so the rows are cut off shortly after the limit
+			assert.Less(t, rows, len(results)/2)
+			assert.Equal(t, []string{
+				fmt.Sprintf("the response was truncated after %d rows, as it exceeded the limit of 8192 bytes", rows),
+			}, notices)
+		})
+	}
+}
+
+func TestQueryStreamResultsFieldsAfterResults(t *testing.T) {
+	results := make([]map[string]interface{}, 1000)
+	for i := range results {
+		results[i] = map[string]interface{}{
+			"time":  time.Date(2024, 1, 23, 0, 0, i, 0, time.UTC).Format(time.RFC3339Nano),
+			"zeta":  float64(i),
+			"alpha": float64(-i),
+		}
+	}
+	// the column fields and the stats come after the results, and the columns aren't in alphabetical order
+	body := fmt.Sprintf(`{"results":%s,"column_fields":[{"name":"time","type":""},{"name":"zeta","type":""},`+
+		`{"name":"alpha","type":""}],"stats":{"elapsed_time_ms":12}}`, marshal(t, results))
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(options ...rockset.RockOption) (plugin.RockClient, error) {
+			return plugin.RockFactory(append(options, rockset.WithHTTPClient(srv.Client()))...)
+		},
+	}
+
+	pc := fakePluginContext()
+	pc.DataSourceInstanceSettings.JSONData = marshal(t, map[string]interface{}{
+		"server": srv.URL, "vi": "vi", "streamResults": true, "maxResponseBytes": 8192,
+	})
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1"}}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: pc,
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	})
+	require.NoError(t, err)
+
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+	frame := res.Frames[0]
+	assert.Less(t, frame.Rows(), len(results)/2)
+	assert.Equal(t, []string{"time", "zeta", "alpha"},
+		[]string{frame.Fields[0].Name, frame.Fields[1].Name, frame.Fields[2].Name})
+	assert.Equal(t, float64(12), frame.Meta.Stats[0].Value)
+	assert.Equal(t, []data.Notice{{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf("the response was truncated after %d rows, as it exceeded the limit of 8192 bytes",
+			frame.Rows()),
+	}}, frame.Meta.Notices)
+}
+
+func TestQueryChunksStreamResults(t *testing.T) {
+	results := make([]map[string]interface{}, 1000)
+	for i := range results {
+		results[i] = map[string]interface{}{
+			"time": time.Date(2024, 1, 23, 0, 0, i, 0, time.UTC).Format(time.RFC3339Nano),
+			"v1":   float64(i),
+		}
+	}
+	body := marshal(t, openapi.QueryResponse{
+		Results:      results,
+		ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+		Stats:        &openapi.QueryResponseStats{ElapsedTimeMs: openapi.PtrInt64(12)},
+	})
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		maxBytes int64
+	}{
+		{name: "all rows"},
+		// each chunk is below the limit, but the combined chunks aren't
+		{name: "truncated", maxBytes: int64(len(body)) * 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ds := plugin.RocksetDatasource{
+				ClientFactory: func(options ...rockset.RockOption) (plugin.RockClient, error) {
+					return plugin.RockFactory(append(options, rockset.WithHTTPClient(srv.Client()))...)
+				},
+			}
+
+			pc := fakePluginContext()
+			pc.DataSourceInstanceSettings.JSONData = marshal(t, map[string]interface{}{
+				"server": srv.URL, "vi": "vi", "streamResults": true, "maxResponseBytes": tc.maxBytes,
+			})
+			qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1"},
+				ChunkInterval: "12h"}
+			from := time.Date(2024, 1, 23, 0, 0, 0, 0, time.UTC)
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: pc,
+				Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm),
+					TimeRange: backend.TimeRange{From: from, To: from.Add(48 * time.Hour)}}},
+			})
+			require.NoError(t, err)
+
+			res := resp.Responses["A"]
+			require.NoError(t, res.Error)
+			require.Len(t, res.Frames, 1)
+			rows := res.Frames[0].Rows()
+
+			var notices []string
+			for _, n := range res.Frames[0].Meta.Notices {
+				notices = append(notices, n.Text)
+			}
+			if tc.maxBytes == 0 {
+				assert.Equal(t, 4*len(results), rows)
+				assert.Empty(t, notices)
+				return
+			}
+			// the chunks are read at the same time, so each is cut off after about half of its rows
+			assert.Greater(t, rows, 0)
+			assert.Less(t, rows, 3*len(results))
+			assert.Equal(t, []string{
+				fmt.Sprintf("the response was truncated after %d rows, as it exceeded the limit of %d bytes",
+					rows, tc.maxBytes),
+			}, notices)
+		})
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	rc := fake.FakeRockClient{}
+	rc.QueryReturns(openapi.QueryResponse{
+		Results:      prepareTestData(t, []testType{{Time: "2024-01-23T19:25:17.000000-08:00", V1: 1}}),
+		ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+		Stats:        &openapi.QueryResponseStats{},
+	}, nil)
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	pc := fakePluginContext()
+	pc.DataSourceInstanceSettings.JSONData = []byte(`{"server":"api.usw2a1.rockset.com","vi":"vi","cacheTTL":"1m","cacheBucket":"1m"}`)
+	from := time.Date(2024, 1, 23, 19, 0, 10, 0, time.UTC)
+
+	query := func(t *testing.T, headers map[string]string, offset time.Duration, qm plugin.MetricsQueryModel) *data.Frame {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: pc,
+			Headers:       headers,
+			Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm),
+				TimeRange: backend.TimeRange{From: from.Add(offset), To: from.Add(time.Hour + offset)}}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+		require.Len(t, resp.Responses["A"].Frames, 1)
+
+		return resp.Responses["A"].Frames[0]
+	}
+	cacheHit := func(frame *data.Frame) interface{} {
+		for _, s := range frame.Meta.Stats {
+			if s.DisplayName == "cache hit" {
+				return s.Value
+			}
+		}
+		return nil
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1",
+		QueryParamStart: ":startTime", QueryParamStop: ":stopTime"}}
+
+	frame := query(t, nil, 0, qm)
+	assert.Equal(t, 0.0, cacheHit(frame))
+	assert.Equal(t, 1, rc.QueryCallCount())
+
+	// the time range is in the same bucket
+	frame = query(t, nil, 30*time.Second, qm)
+	assert.Equal(t, 1.0, cacheHit(frame))
+	assert.Equal(t, 1, rc.QueryCallCount())
+	assert.Equal(t, 1, frame.Rows())
+
+	// the time range is in the next bucket
+	query(t, nil, time.Minute, qm)
+	assert.Equal(t, 2, rc.QueryCallCount())
+
+	// alert rules always query Rockset
+	frame = query(t, map[string]string{"FromAlert": "true"}, 0, qm)
+	assert.Nil(t, cacheHit(frame))
+	assert.Equal(t, 3, rc.QueryCallCount())
+
+	// the query turns off caching
+	qm.CacheTTL = "0s"
+	frame = query(t, nil, 0, qm)
+	assert.Nil(t, cacheHit(frame))
+	assert.Equal(t, 4, rc.QueryCallCount())
+
+	// a different query isn't cached
+	qm.CacheTTL = ""
+	qm.QueryText = "SELECT 2"
+	frame = query(t, nil, 0, qm)
+	assert.Equal(t, 0.0, cacheHit(frame))
+	assert.Equal(t, 5, rc.QueryCallCount())
+}
+
+func TestQueryDeduplication(t *testing.T) {
+	qr := openapi.QueryResponse{
+		Results:      prepareTestData(t, []testType{{Time: "2024-01-23T19:25:17.000000-08:00", V1: 1}}),
+		ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+		Stats:        &openapi.QueryResponseStats{},
+	}
+
+	release := make(chan struct{})
+	rc := fake.FakeRockClient{}
+	rc.QueryStub = func(ctx context.Context, _ string, _ ...option.QueryOption) (openapi.QueryResponse, error) {
+		select {
+		case <-release:
+			return qr, nil
+		case <-ctx.Done():
+			return openapi.QueryResponse{}, ctx.Err()
+		}
+	}
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	query := func(ctx context.Context, user string) backend.DataResponse {
+		pc := fakePluginContext()
+		pc.User = &backend.User{Login: user}
+		qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1"}}
+		resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{
+			PluginContext: pc,
+			Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+		})
+		require.NoError(t, err)
+
+		return resp.Responses["A"]
+	}
+
+	// the first caller gives up, which mustn't cancel the query for the others
+	ctx, cancel := context.WithCancel(context.Background())
+	responses := make(chan backend.DataResponse, 3)
+	go func() { responses <- query(ctx, "first") }()
+	for _, user := range []string{"second", "third"} {
+		go func(user string) { responses <- query(context.Background(), user) }(user)
+	}
+
+	// give the callers time to join the query in flight
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	res := <-responses
+	assert.Equal(t, backend.StatusTimeout, res.Status)
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		res = <-responses
+		require.NoError(t, res.Error)
+		require.Len(t, res.Frames, 1)
+		assert.Equal(t, 1, res.Frames[0].Rows())
+	}
+	assert.Equal(t, 1, rc.QueryCallCount())
+}
+
+func TestQueryIncremental(t *testing.T) {
+	at := func(s string) string { return "2024-01-23T" + s + "Z" }
+	result := func(rows ...map[string]interface{}) openapi.QueryResponse {
+		return openapi.QueryResponse{
+			Results:      rows,
+			ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "host"}, {Name: "v1"}},
+			Stats:        &openapi.QueryResponseStats{},
+		}
+	}
+
+	rc := fake.FakeRockClient{}
+	rc.QueryReturnsOnCall(0, result(
+		map[string]interface{}{"time": at("10:00:00"), "host": "a", "v1": float64(1)},
+		map[string]interface{}{"time": at("10:30:00"), "host": "a", "v1": float64(2)},
+		map[string]interface{}{"time": at("10:59:30"), "host": "a", "v1": float64(3)},
+		map[string]interface{}{"time": at("10:30:00"), "host": "b", "v1": float64(5)},
+	), nil)
+	// the overlap picks up the late value at 10:59:30, and host b has no new rows
+	rc.QueryReturnsOnCall(1, result(
+		map[string]interface{}{"time": at("10:59:30"), "host": "a", "v1": float64(30)},
+		map[string]interface{}{"time": at("11:01:00"), "host": "a", "v1": float64(4)},
+	), nil)
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1",
+		QueryParamStart: ":startTime", QueryParamStop: ":stopTime"},
+		QueryLabelColumn: "host", Incremental: true, IncrementalOverlap: "1m"}
+	from := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+	query := func(offset time.Duration) backend.DataResponse {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: fakePluginContext(),
+			Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm),
+				TimeRange: backend.TimeRange{From: from.Add(offset), To: from.Add(time.Hour + offset)}}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+
+		return resp.Responses["A"]
+	}
+
+	res := query(0)
+	require.Len(t, res.Frames, 2)
+
+	res = query(2 * time.Minute)
+	require.Equal(t, 2, rc.QueryCallCount())
+
+	// the second query only covers the time since the previous refresh, minus the overlap
+	_, _, options := rc.QueryArgsForCall(1)
+	request := option.QueryOptions{QueryRequest: openapi.NewQueryRequestWithDefaults()}
+	for _, o := range options {
+		o(&request)
+	}
+	assert.Equal(t, "2024-01-23T10:59:00Z", request.Sql.Parameters[0].Value)
+
+	require.Len(t, res.Frames, 2)
+	var times []time.Time
+	var values []float64
+	for i := 0; i < res.Frames[0].Rows(); i++ {
+		times = append(times, res.Frames[0].Fields[0].At(i).(time.Time))
+		values = append(values, *res.Frames[0].Fields[1].At(i).(*float64))
+	}
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 1, 23, 10, 30, 0, 0, time.UTC),
+		time.Date(2024, 1, 23, 10, 59, 30, 0, time.UTC),
+		time.Date(2024, 1, 23, 11, 1, 0, 0, time.UTC),
+	}, times)
+	assert.Equal(t, []float64{2, 30, 4}, values)
+	assert.Equal(t, 1, res.Frames[1].Rows())
+	assert.Equal(t, data.Labels{"host": "b"}, res.Frames[1].Fields[1].Labels)
+}
+
+func TestQueryIncrementalTimeBuckets(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, "2024-01-23T"+s+"Z")
+		require.NoError(t, err)
+		return v
+	}
+	rc := fake.FakeRockClient{}
+	rc.QueryStub = func(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+		request := option.QueryOptions{QueryRequest: openapi.NewQueryRequestWithDefaults()}
+		for _, o := range options {
+			o(&request)
+		}
+		var rows []map[string]interface{}
+		switch start := request.Sql.Parameters[1].Value; start {
+		case "2024-01-23T10:00:00Z":
+			for b := at("10:00:00"); !b.After(at("11:00:00")); b = b.Add(5 * time.Minute) {
+				rows = append(rows, map[string]interface{}{"time": b.Format(time.RFC3339), "v1": float64(1)})
+			}
+			// the bucket at 11:00 only has the rows up to 11:02
+			rows[len(rows)-1]["v1"] = float64(40)
+		case "2024-01-23T11:00:00Z":
+			// the whole bucket at 11:00 is queried again
+			rows = append(rows,
+				map[string]interface{}{"time": at("11:00:00").Format(time.RFC3339), "v1": float64(50)},
+				map[string]interface{}{"time": at("11:05:00").Format(time.RFC3339), "v1": float64(3)})
+		default:
+			// TIME_BUCKET stamps the rows after the start with the start of their bucket, which is before it
+			rows = append(rows,
+				map[string]interface{}{"time": at("11:00:00").Format(time.RFC3339), "v1": float64(5)},
+				map[string]interface{}{"time": at("11:05:00").Format(time.RFC3339), "v1": float64(3)})
+		}
+
+		return openapi.QueryResponse{
+			Results:      rows,
+			ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+			Stats:        &openapi.QueryResponseStats{},
+		}, nil
+	}
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1",
+		QueryParamStart: ":startTime", QueryParamStop: ":stopTime",
+		BaseQueryModel: plugin.BaseQueryModel{IntervalMs: 300_000}},
+		Incremental: true, IncrementalOverlap: "1m"}
+	query := func(from, to time.Time) backend.DataResponse {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: fakePluginContext(),
+			Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm),
+				TimeRange: backend.TimeRange{From: from, To: to}}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+
+		return resp.Responses["A"]
+	}
+
+	query(at("10:00:00"), at("11:02:00"))
+	// the previous refresh ended at 11:02, so 11:01 is rounded down to the bucket at 11:00
+	res := query(at("10:05:00"), at("11:07:00"))
+	require.Equal(t, 2, rc.QueryCallCount())
+
+	require.Len(t, res.Frames, 1)
+	values := make(map[time.Time]float64)
+	var times []time.Time
+	for i := 0; i < res.Frames[0].Rows(); i++ {
+		v := res.Frames[0].Fields[0].At(i).(time.Time)
+		times = append(times, v)
+		values[v] = *res.Frames[0].Fields[1].At(i).(*float64)
+	}
+	assert.Len(t, times, 13)
+	assert.Len(t, values, 13, "every bucket is in the frame once")
+	assert.Equal(t, at("10:05:00"), times[0])
+	assert.Equal(t, float64(50), values[at("11:00:00")])
+	assert.Equal(t, float64(3), values[at("11:05:00")])
+}
+
+func TestQueryIncrementalDownsample(t *testing.T) {
+	rc := fake.FakeRockClient{}
+	// a row for each minute of the time range
+	rc.QueryStub = func(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+		request := option.QueryOptions{QueryRequest: openapi.NewQueryRequestWithDefaults()}
+		for _, o := range options {
+			o(&request)
+		}
+		start, err := time.Parse(time.RFC3339, request.Sql.Parameters[0].Value)
+		require.NoError(t, err)
+		stop, err := time.Parse(time.RFC3339, request.Sql.Parameters[1].Value)
+		require.NoError(t, err)
+
+		var rows []map[string]interface{}
+		for at := start; at.Before(stop); at = at.Add(time.Minute) {
+			rows = append(rows, map[string]interface{}{"time": at.Format(time.RFC3339), "v1": float64(at.Minute())})
+		}
+
+		return openapi.QueryResponse{
+			Results:      rows,
+			ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+			Stats:        &openapi.QueryResponseStats{},
+		}, nil
+	}
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1",
+		QueryParamStart: ":startTime", QueryParamStop: ":stopTime", MaxDataPoints: 100},
+		Incremental: true, IncrementalOverlap: "1m", Downsample: plugin.DownsampleLTTB}
+	from := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+	query := func(offset time.Duration) backend.DataResponse {
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+			PluginContext: fakePluginContext(),
+			Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm), MaxDataPoints: 100,
+				TimeRange: backend.TimeRange{From: from.Add(offset), To: from.Add(200*time.Minute + offset)}}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+
+		return resp.Responses["A"]
+	}
+
+	for _, offset := range []time.Duration{0, time.Hour, 2 * time.Hour} {
+		res := query(offset)
+		require.Len(t, res.Frames, 1)
+		frame := res.Frames[0]
+		assert.Equal(t, 100, frame.Rows())
+
+		// the first and the last row of the merged series are kept
+		assert.Equal(t, from.Add(offset), frame.Fields[0].At(0))
+		assert.Equal(t, from.Add(offset+199*time.Minute), frame.Fields[0].At(frame.Rows()-1))
+		require.NotNil(t, frame.Meta)
+		assert.Contains(t, frame.Meta.Notices, data.Notice{Severity: data.NoticeSeverityInfo,
+			Text: "1 of 1 series were downsampled to 100 points using lttb"})
+	}
+	assert.Equal(t, 3, rc.QueryCallCount())
+}
+
+func TestQueryChunks(t *testing.T) {
+	from := time.Date(2024, 1, 23, 10, 30, 0, 0, time.UTC)
+
+	rc := fake.FakeRockClient{}
+	rc.QueryStub = func(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+		request := option.QueryOptions{QueryRequest: openapi.NewQueryRequestWithDefaults()}
+		for _, o := range options {
+			o(&request)
+		}
+		start := request.Sql.Parameters[0].Value
+		if start == "2024-01-23T12:00:00Z" {
+			return openapi.QueryResponse{}, errors.New("chunk failed")
+		}
+
+		return openapi.QueryResponse{
+			Results:      []map[string]interface{}{{"time": start, "v1": float64(1)}},
+			ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+			Stats:        &openapi.QueryResponseStats{},
+		}, nil
+	}
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1",
+		QueryParamStart: ":startTime", QueryParamStop: ":stopTime"}, ChunkInterval: "1h"}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: fakePluginContext(),
+		Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm),
+			TimeRange: backend.TimeRange{From: from, To: from.Add(3 * time.Hour)}}},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+
+	// the chunks are aligned to the hour, so the first and last chunks are half an hour
+	require.Equal(t, 4, rc.QueryCallCount())
+
+	require.Len(t, res.Frames, 1)
+	var times []time.Time
+	for i := 0; i < res.Frames[0].Rows(); i++ {
+		times = append(times, res.Frames[0].Fields[0].At(i).(time.Time))
+	}
+	assert.Equal(t, []time.Time{from, from.Add(30 * time.Minute), from.Add(150 * time.Minute)}, times)
+	assert.Equal(t, []data.Notice{{
+		Severity: data.NoticeSeverityWarning,
+		Text:     "the query failed for 2024-01-23T12:00:00Z to 2024-01-23T13:00:00Z, so the rows are missing: chunk failed",
+	}}, res.Frames[0].Meta.Notices)
+}
+
+func TestQueryChunksRowLimit(t *testing.T) {
+	from := time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)
+
+	for _, maxRowLimit := range []int32{1, 5} {
+		t.Run(fmt.Sprint(maxRowLimit), func(t *testing.T) {
+			rc := fake.FakeRockClient{}
+			rc.QueryStub = func(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+				request := option.QueryOptions{QueryRequest: openapi.NewQueryRequestWithDefaults()}
+				for _, o := range options {
+					o(&request)
+				}
+				start, err := time.Parse(time.RFC3339, request.Sql.Parameters[0].Value)
+				require.NoError(t, err)
+
+				// two rows for each chunk, limited by the default row limit
+				rows := []map[string]interface{}{
+					{"time": start.Format(time.RFC3339), "v1": float64(1)},
+					{"time": start.Add(time.Hour).Format(time.RFC3339), "v1": float64(2)},
+				}
+				if limit := int(request.Sql.GetDefaultRowLimit()); limit > 0 && limit < len(rows) {
+					rows = rows[:limit]
+				}
+
+				return openapi.QueryResponse{
+					Results:      rows,
+					ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+					Stats:        &openapi.QueryResponseStats{},
+				}, nil
+			}
+
+			ds := plugin.RocksetDatasource{
+				ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+					return &rc, nil
+				},
+			}
+
+			pc := fakePluginContext()
+			pc.DataSourceInstanceSettings.JSONData = marshal(t, map[string]interface{}{
+				"server": "api.usw2a1.rockset.com", "vi": "vi", "maxRowLimit": maxRowLimit,
+			})
+			qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1",
+				QueryParamStart: ":startTime", QueryParamStop: ":stopTime"}, ChunkInterval: "1d"}
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: pc,
+				Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm),
+					TimeRange: backend.TimeRange{From: from, To: from.Add(10 * 24 * time.Hour)}}},
+			})
+			require.NoError(t, err)
+			res := resp.Responses["A"]
+			require.NoError(t, res.Error)
+			require.Equal(t, 10, rc.QueryCallCount())
+
+			// the limit applies to the combined chunks, and the warning is only shown once
+			require.Len(t, res.Frames, 1)
+			assert.Equal(t, int(maxRowLimit), res.Frames[0].Rows())
+			assert.Equal(t, []data.Notice{{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("the result was truncated to the row limit of %d rows", maxRowLimit),
+			}}, res.Frames[0].Meta.Notices)
+
+			qm.ChunkInterval = "1x"
+			resp, err = ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: pc,
+				Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, backend.StatusBadRequest, resp.Responses["A"].Status)
+			assert.Equal(t, 10, rc.QueryCallCount())
+		})
+	}
+}
+
+func TestQueryTimeOffsets(t *testing.T) {
+	from := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+
+	rc := fake.FakeRockClient{}
+	rc.QueryStub = func(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+		request := option.QueryOptions{QueryRequest: openapi.NewQueryRequestWithDefaults()}
+		for _, o := range options {
+			o(&request)
+		}
+		start := request.Sql.Parameters[0].Value
+		if start == "2024-01-16T10:00:00Z" {
+			return openapi.QueryResponse{}, errors.New("offset failed")
+		}
+
+		return openapi.QueryResponse{
+			Results:      []map[string]interface{}{{"time": start, "host": "a", "v1": float64(1)}},
+			ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "host"}, {Name: "v1"}},
+			Stats:        &openapi.QueryResponseStats{},
+		}, nil
+	}
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1",
+		QueryParamStart: ":startTime", QueryParamStop: ":stopTime"}, QueryLabelColumn: "host",
+		TimeOffsets: []string{"1d", "1w"}}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: fakePluginContext(),
+		Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm),
+			TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)}}},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Equal(t, 3, rc.QueryCallCount())
+
+	// the series of the previous day is moved onto the time range
+	require.Len(t, res.Frames, 2)
+	assert.Equal(t, from, res.Frames[0].Fields[0].At(0))
+	assert.Equal(t, data.Labels{"host": "a"}, res.Frames[0].Fields[1].Labels)
+	assert.Equal(t, from, res.Frames[1].Fields[0].At(0))
+	assert.Equal(t, data.Labels{"host": "a", plugin.OffsetLabel: "1d"}, res.Frames[1].Fields[1].Labels)
+	assert.Equal(t, []data.Notice{{
+		Severity: data.NoticeSeverityWarning,
+		Text:     "the query with time offset 1w failed: There was a problem executing your query:\noffset failed",
+	}}, res.Frames[0].Meta.Notices)
+
+	qm.TimeOffsets = []string{"-1d"}
+	resp, err = ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: fakePluginContext(),
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, backend.StatusBadRequest, resp.Responses["A"].Status)
+}
+
+func TestQueryFill(t *testing.T) {
+	from := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+	f := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		fill   string
+		values []*float64
+	}{
+		{fill: plugin.FillNull, values: []*float64{nil, f(1), nil, nil, f(4)}},
+		{fill: plugin.FillZero, values: []*float64{f(0), f(1), f(0), f(0), f(4)}},
+		{fill: plugin.FillPrevious, values: []*float64{nil, f(1), f(1), f(1), f(4)}},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.fill, func(t *testing.T) {
+			rc := fake.FakeRockClient{}
+			rc.QueryReturns(openapi.QueryResponse{
+				Results: []map[string]interface{}{
+					{"time": "2024-01-23T10:04:00Z", "v1": float64(4)},
+					{"time": "2024-01-23T10:01:00Z", "v1": float64(1)},
+				},
+				ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+				Stats:        &openapi.QueryResponseStats{},
+			}, nil)
+
+			ds := plugin.RocksetDatasource{
+				ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+					return &rc, nil
+				},
+			}
+
+			qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1",
+				BaseQueryModel: plugin.BaseQueryModel{IntervalMs: 60000}}, Fill: tst.fill}
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: fakePluginContext(),
+				Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm),
+					TimeRange: backend.TimeRange{From: from.Add(30 * time.Second), To: from.Add(5 * time.Minute)}}},
+			})
+			require.NoError(t, err)
+			res := resp.Responses["A"]
+			require.NoError(t, res.Error)
+
+			require.Len(t, res.Frames, 1)
+			var times []time.Time
+			var values []*float64
+			for i := 0; i < res.Frames[0].Rows(); i++ {
+				times = append(times, res.Frames[0].Fields[0].At(i).(time.Time))
+				values = append(values, res.Frames[0].Fields[1].At(i).(*float64))
+			}
+			assert.Equal(t, []time.Time{from, from.Add(time.Minute), from.Add(2 * time.Minute),
+				from.Add(3 * time.Minute), from.Add(4 * time.Minute)}, times)
+			assert.Equal(t, tst.values, values)
+		})
+	}
+}
+
+func TestQueryDownsample(t *testing.T) {
+	from := time.Date(2024, 1, 23, 10, 0, 0, 0, time.UTC)
+	var results []map[string]interface{}
+	for i := 0; i < 100; i++ {
+		v := float64(i % 2)
+		if i == 45 {
+			v = 100
+		}
+		results = append(results, map[string]interface{}{
+			"time": from.Add(time.Duration(i) * time.Second).Format(time.RFC3339), "v1": v})
+	}
+
+	tests := []struct {
+		downsample string
+		rows       int
+		first      float64
+		spike      bool
+	}{
+		{downsample: "", rows: 100, first: 0, spike: true},
+		{downsample: plugin.DownsampleLTTB, rows: 10, first: 0, spike: true},
+		{downsample: plugin.DownsampleMinMax, rows: 10, first: 0, spike: true},
+		{downsample: plugin.DownsampleAverage, rows: 10, first: 0.5, spike: false},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.downsample, func(t *testing.T) {
+			rc := fake.FakeRockClient{}
+			rc.QueryReturns(openapi.QueryResponse{
+				Results:      results,
+				ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+				Stats:        &openapi.QueryResponseStats{},
+			}, nil)
+
+			ds := plugin.RocksetDatasource{
+				ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+					return &rc, nil
+				},
+			}
+
+			qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1",
+				MaxDataPoints: 10, RowLimit: 100}, Downsample: tst.downsample}
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: fakePluginContext(),
+				Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm),
+					TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)}}},
+			})
+			require.NoError(t, err)
+			res := resp.Responses["A"]
+			require.NoError(t, res.Error)
+
+			// MaxDataPoints is no longer the row limit, and one more row is asked for to detect truncation
+			_, _, options := rc.QueryArgsForCall(0)
+			request := option.QueryOptions{QueryRequest: openapi.NewQueryRequestWithDefaults()}
+			for _, o := range options {
+				o(&request)
+			}
+			assert.Equal(t, int32(101), request.Sql.GetDefaultRowLimit())
+
+			require.Len(t, res.Frames, 1)
+			frame := res.Frames[0]
+			require.Equal(t, tst.rows, frame.Rows())
+			assert.Equal(t, tst.first, *frame.Fields[1].At(0).(*float64))
+			spike := false
+			for i := 0; i < frame.Rows(); i++ {
+				spike = spike || *frame.Fields[1].At(i).(*float64) == 100
+			}
+			assert.Equal(t, tst.spike, spike)
+
+			var notices []data.Notice
+			if tst.downsample != "" {
+				notices = append(notices, data.Notice{
+					Severity: data.NoticeSeverityInfo,
+					Text:     "1 of 1 series were downsampled to 10 points using " + tst.downsample,
+				})
+			}
+			assert.Equal(t, notices, frame.Meta.Notices)
+		})
+	}
+}
+
+func TestQueryRowLimit(t *testing.T) {
+	var results []map[string]interface{}
+	for i := 0; i < 10; i++ {
+		results = append(results, map[string]interface{}{
+			"time": time.Date(2024, 1, 23, 0, 0, i, 0, time.UTC).Format(time.RFC3339), "v1": float64(i)})
+	}
+
+	tests := []struct {
+		name        string
+		maxRowLimit int32
+		rowLimit    int32
+		requested   int32
+		rows        int
+	}{
+		{name: "no limit", rows: 10},
+		{name: "query limit", rowLimit: 5, requested: 6, rows: 5},
+		{name: "datasource limit", maxRowLimit: 3, requested: 4, rows: 3},
+		{name: "query can't raise the limit", maxRowLimit: 3, rowLimit: 5, requested: 4, rows: 3},
+		{name: "query can lower the limit", maxRowLimit: 3, rowLimit: 2, requested: 3, rows: 2},
+		{name: "not truncated", maxRowLimit: 10, requested: 11, rows: 10},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := fake.FakeRockClient{}
+			rc.QueryStub = func(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+				request := option.QueryOptions{QueryRequest: openapi.NewQueryRequestWithDefaults()}
+				for _, o := range options {
+					o(&request)
+				}
+				rows := results
+				if limit := int(request.Sql.GetDefaultRowLimit()); limit > 0 && limit < len(rows) {
+					rows = rows[:limit]
+				}
+
+				return openapi.QueryResponse{
+					Results:      rows,
+					ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+					Stats:        &openapi.QueryResponseStats{},
+				}, nil
+			}
+
+			ds := plugin.RocksetDatasource{
+				ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+					return &rc, nil
+				},
+			}
+
+			pc := fakePluginContext()
+			pc.DataSourceInstanceSettings.JSONData = marshal(t, map[string]interface{}{
+				"server": "api.usw2a1.rockset.com", "vi": "vi", "maxRowLimit": tc.maxRowLimit,
+			})
+			qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1",
+				RowLimit: tc.rowLimit}}
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: pc,
+				Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+			})
+			require.NoError(t, err)
+			res := resp.Responses["A"]
+			require.NoError(t, res.Error)
+
+			_, _, options := rc.QueryArgsForCall(0)
+			request := option.QueryOptions{QueryRequest: openapi.NewQueryRequestWithDefaults()}
+			for _, o := range options {
+				o(&request)
+			}
+			assert.Equal(t, tc.requested, request.Sql.GetDefaultRowLimit())
+
+			require.Len(t, res.Frames, 1)
+			assert.Equal(t, tc.rows, res.Frames[0].Rows())
+			if tc.rows == len(results) {
+				assert.Empty(t, res.Frames[0].Meta.Notices)
+				return
+			}
+			assert.Equal(t, []data.Notice{{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("the result was truncated to the row limit of %d rows", tc.rows),
+			}}, res.Frames[0].Meta.Notices)
+		})
+	}
+}
+
+func TestQueryReadOnly(t *testing.T) {
+	tests := []struct {
+		name        string
+		sql         string
+		allowWrites bool
+		keyword     string
+	}{
+		{name: "select", sql: "SELECT * FROM commons._events"},
+		{name: "parentheses", sql: "(SELECT 1) UNION ALL (SELECT 2)"},
+		{name: "with", sql: "WITH e AS (SELECT * FROM commons._events) SELECT * FROM e"},
+		{name: "explain", sql: "EXPLAIN INSERT INTO commons.c SELECT 1"},
+		{name: "keywords in strings and comments", sql: "-- DELETE FROM commons.c\nSELECT 'x; DELETE' AS \"insert\"; /* ; */"},
+		{name: "column named like a keyword", sql: "SELECT e.update FROM commons._events e"},
+		{name: "insert", sql: "INSERT INTO commons.c SELECT 1", keyword: "INSERT"},
+		{name: "lower case", sql: "  delete FROM commons.c", keyword: "DELETE"},
+		{name: "after comment", sql: "/* dashboard */ UPDATE commons.c SET x = 1", keyword: "UPDATE"},
+		{name: "second statement", sql: "SELECT 1; DROP COLLECTION commons.c", keyword: "DROP"},
+		{name: "with insert", sql: "WITH e AS (SELECT 1) INSERT INTO commons.c SELECT * FROM e", keyword: "INSERT"},
+		{name: "unknown statement", sql: "CREATE ALIAS a WITH commons.c", keyword: "CREATE"},
+		{name: "writes allowed", sql: "INSERT INTO commons.c SELECT 1", allowWrites: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := fake.FakeRockClient{}
+			rc.QueryReturns(openapi.QueryResponse{Stats: &openapi.QueryResponseStats{}}, nil)
+
+			ds := plugin.RocksetDatasource{
+				ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+					return &rc, nil
+				},
+			}
+
+			pc := fakePluginContext()
+			pc.DataSourceInstanceSettings.JSONData = marshal(t, map[string]interface{}{
+				"server": "api.usw2a1.rockset.com", "vi": "vi", "allowWrites": tc.allowWrites,
+			})
+			qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: tc.sql}}
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: pc,
+				Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+			})
+			require.NoError(t, err)
+			res := resp.Responses["A"]
+
+			if tc.keyword == "" {
+				assert.NoError(t, res.Error)
+				assert.Equal(t, 1, rc.QueryCallCount())
+				return
+			}
+			assert.Equal(t, 0, rc.QueryCallCount())
+			assert.Equal(t, backend.StatusForbidden, res.Status)
+			assert.Equal(t, backend.ErrorSourceDownstream, res.ErrorSource)
+			assert.ErrorContains(t, res.Error,
+				tc.keyword+" statements aren't allowed, as the datasource only allows queries which read")
+		})
+	}
+}
+
+func TestQueryCollectionRules(t *testing.T) {
+	tests := []struct {
+		name       string
+		sql        string
+		collection string
+		err        string
+	}{
+		{name: "allowed", sql: "SELECT * FROM analytics.page_views"},
+		{name: "default workspace", sql: "SELECT * FROM _events"},
+		{name: "joins", sql: "SELECT * FROM analytics.a AS x JOIN \"analytics\".`b` y ON x.id = y.id, analytics.c z"},
+		{name: "common table expression", sql: "WITH e AS (SELECT * FROM analytics.a) SELECT * FROM e"},
+		{name: "functions with FROM", sql: "SELECT EXTRACT(HOUR FROM e._event_time) FROM analytics.a e " +
+			"WHERE e.x IS DISTINCT FROM e.y"},
+		{name: "string and comment", sql: "SELECT 'FROM other.c' FROM analytics.a -- JOIN other.c"},
+		{name: "not allowed", sql: "SELECT * FROM other.c", collection: "other.c"},
+		{name: "not allowed in default workspace", sql: "SELECT * FROM users", collection: "commons.users"},
+		{name: "denied", sql: "SELECT * FROM analytics.secret_keys", collection: "analytics.secret_keys"},
+		{name: "subquery", sql: "SELECT * FROM analytics.a WHERE id IN (SELECT id FROM other.c)", collection: "other.c"},
+		{name: "comma join", sql: "SELECT * FROM analytics.a x, other.c y", collection: "other.c"},
+		{name: "common table expression named like a collection",
+			sql: "WITH users AS (SELECT * FROM users) SELECT * FROM users", collection: "commons.users"},
+		{name: "common table expression used by the next", sql: "WITH a AS (SELECT * FROM analytics.a), " +
+			"b AS (SELECT * FROM a) SELECT * FROM b"},
+		{name: "unnest", sql: "SELECT * FROM analytics.a CROSS JOIN UNNEST(a.x) u, LATERAL analytics.b"},
+		{name: "join condition before a comma join", sql: "SELECT * FROM analytics.a JOIN analytics.b " +
+			"ON a.id = b.id, analytics.c"},
+		{name: "insert with columns", sql: "INSERT INTO analytics.a (x, y) SELECT x, y FROM analytics.b"},
+		{name: "subquery before a comma join", sql: "SELECT * FROM (SELECT 1) a, commons.secret",
+			collection: "commons.secret"},
+		{name: "unnest before a comma join", sql: "SELECT * FROM analytics.a CROSS JOIN UNNEST(a.x) u, secret",
+			collection: "commons.secret"},
+		{name: "collection in parentheses", sql: "SELECT * FROM (commons.secret)", collection: "commons.secret"},
+		{name: "lateral", sql: "SELECT * FROM analytics.ok, LATERAL secret", collection: "commons.secret"},
+		{name: "join condition then comma join", sql: "SELECT * FROM analytics.a JOIN analytics.b ON a.id = b.id, " +
+			"other.c", collection: "other.c"},
+		{name: "insert into", sql: "INSERT INTO other.c (x) SELECT x FROM analytics.a", collection: "other.c"},
+		{name: "table function", sql: "SELECT * FROM analytics.a, secret(1)",
+			err: "the datasource can't check the collections after FROM, so the query isn't allowed"},
+		{name: "parameter", sql: "SELECT * FROM :collection",
+			err: "the datasource can't check the collections after FROM, so the query isn't allowed"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := fake.FakeRockClient{}
+			rc.QueryReturns(openapi.QueryResponse{Stats: &openapi.QueryResponseStats{}}, nil)
+
+			ds := plugin.RocksetDatasource{
+				ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+					return &rc, nil
+				},
+			}
+
+			pc := fakePluginContext()
+			pc.DataSourceInstanceSettings.JSONData = marshal(t, map[string]interface{}{
+				"server": "api.usw2a1.rockset.com", "vi": "vi",
+				"allowedCollections": []string{"analytics.*", "commons._events"},
+				"deniedCollections":  []string{"*.secret_*"},
+				"allowWrites":        true,
+			})
+			qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: tc.sql}}
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: pc,
+				Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+			})
+			require.NoError(t, err)
+			res := resp.Responses["A"]
+
+			if tc.collection != "" {
+				tc.err = "the datasource isn't allowed to query the collection " + tc.collection
+			}
+			if tc.err == "" {
+				assert.NoError(t, res.Error)
+				assert.Equal(t, 1, rc.QueryCallCount())
+				return
+			}
+			assert.Equal(t, 0, rc.QueryCallCount())
+			assert.Equal(t, backend.StatusForbidden, res.Status)
+			assert.ErrorContains(t, res.Error, tc.err)
+		})
+	}
+}
+
+func TestQueryErrorSources(t *testing.T) {
+	tests := []struct {
+		name   string
+		refID  string
+		json   []byte
+		rows   []map[string]interface{}
+		status backend.Status
+	}{
+		{
+			name:   "time column isn't a string",
+			refID:  "A",
+			json:   []byte(`{"queryTimeField": "time", "queryText": "SELECT 1"}`),
+			rows:   []map[string]interface{}{{"time": float64(1), "v1": float64(1)}},
+			status: backend.StatusUnknown,
+		},
+		{
+			name:   "annotation time column isn't a string",
+			refID:  "Anno",
+			json:   []byte(`{"queryTimeField": "time", "queryText": "SELECT 1"}`),
+			rows:   []map[string]interface{}{{"time": float64(1), "v1": float64(1)}},
+			status: backend.StatusUnknown,
+		},
+		{
+			name:   "invalid query",
+			refID:  "A",
+			json:   []byte(`{"queryText": 1}`),
+			status: backend.StatusBadRequest,
+		},
+		{
+			name:   "invalid annotation query",
+			refID:  "Anno",
+			json:   []byte(`{"queryText": 1}`),
+			status: backend.StatusBadRequest,
+		},
+		{
+			name:   "invalid variable query",
+			refID:  "variable-query",
+			json:   []byte(`{"queryText": 1}`),
+			status: backend.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := fake.FakeRockClient{}
+			rc.QueryReturns(openapi.QueryResponse{
+				Results:      tc.rows,
+				ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+				Stats:        &openapi.QueryResponseStats{},
+			}, nil)
+
+			ds := plugin.RocksetDatasource{
+				ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+					return &rc, nil
+				},
+			}
+
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: fakePluginContext(),
+				Queries:       []backend.DataQuery{{RefID: tc.refID, JSON: tc.json}},
+			})
+			require.NoError(t, err)
+
+			res := resp.Responses[tc.refID]
+			require.Error(t, res.Error)
+			assert.Equal(t, tc.status, res.Status)
+			assert.Equal(t, backend.ErrorSourceDownstream, res.ErrorSource)
+		})
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status backend.Status
+		source backend.ErrorSource
+	}{
+		{
+			name: "invalid SQL",
+			err: rockerr.Error{StatusCode: http.StatusBadRequest,
+				ErrorModel: &openapi.ErrorModel{Type: openapi.PtrString("QUERY_ERROR"), Message: openapi.PtrString("syntax error")}},
+			status: backend.StatusBadRequest,
+			source: backend.ErrorSourceDownstream,
+		},
+		{
+			name: "query timeout",
+			err: rockerr.Error{StatusCode: http.StatusRequestTimeout,
+				ErrorModel: &openapi.ErrorModel{Type: openapi.PtrString("QUERY_TIMEOUT"), Message: openapi.PtrString("timeout")}},
+			status: backend.StatusTimeout,
+			source: backend.ErrorSourceDownstream,
+		},
+		{
+			name: "rockset outage",
+			err: rockerr.Error{StatusCode: http.StatusInternalServerError,
+				ErrorModel: &openapi.ErrorModel{Type: openapi.PtrString("INTERNALERROR"), Message: openapi.PtrString("oops")}},
+			status: backend.StatusBadGateway,
+			source: backend.ErrorSourceDownstream,
+		},
+		{
+			name:   "rate limited without error type",
+			err:    rockerr.Error{StatusCode: http.StatusTooManyRequests, Cause: errors.New("too many requests")},
+			status: backend.StatusTooManyRequests,
+			source: backend.ErrorSourceDownstream,
+		},
+		{
+			name:   "connection failure",
+			err:    rockerr.Error{Cause: errors.New("connection refused")},
+			status: backend.StatusBadGateway,
+			source: backend.ErrorSourceDownstream,
+		},
+		{
+			name:   "deadline exceeded",
+			err:    fmt.Errorf("query: %w", context.DeadlineExceeded),
+			status: backend.StatusTimeout,
+			source: backend.ErrorSourceDownstream,
+		},
+		{
+			name:   "plugin error",
+			err:    errors.New("failed to build request"),
+			status: backend.StatusInternal,
+			source: backend.ErrorSourcePlugin,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := fake.FakeRockClient{}
+			rc.QueryReturns(openapi.QueryResponse{}, tc.err)
+
+			ds := plugin.RocksetDatasource{
+				ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+					return &rc, nil
+				},
+			}
+
+			qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryText: "SELECT 1"}}
+			resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+				PluginContext: fakePluginContext(),
+				Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+			})
+			require.NoError(t, err)
+
+			res := resp.Responses["A"]
+			require.Error(t, res.Error)
+			assert.Equal(t, tc.status, res.Status)
+			assert.Equal(t, tc.source, res.ErrorSource)
+		})
+	}
+}
+
+func TestQueryErrorSnippet(t *testing.T) {
+	rc := fake.FakeRockClient{}
+	rc.QueryReturns(openapi.QueryResponse{}, rockerr.Error{StatusCode: http.StatusBadRequest,
+		ErrorModel: &openapi.ErrorModel{
+			Type:    openapi.PtrString("QUERY_ERROR"),
+			Message: openapi.PtrString("Collection 'fo' not found"),
+			Line:    openapi.PtrInt32(3),
+			Column:  openapi.PtrInt32(6),
+		}})
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{
+		QueryText: "SELECT\n\ttime, v1\nFROM\tfo\nWHERE v1 > 1",
+	}}
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: fakePluginContext(),
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	})
+	require.NoError(t, err)
+
+	res := resp.Responses["A"]
+	require.Error(t, res.Error)
+	assert.Contains(t, res.Error.Error(), "Collection 'fo' not found\n\n"+
+		"2 | \ttime, v1\n"+
+		"3 | FROM\tfo\n"+
+		"  |     \t^\n"+
+		"4 | WHERE v1 > 1")
+}
+
+func TestQueryLogging(t *testing.T) {
+	logger := &recordingLogger{}
+	defaultLogger := log.DefaultLogger
+	log.DefaultLogger = logger
+	t.Cleanup(func() { log.DefaultLogger = defaultLogger })
+
+	rc := fake.FakeRockClient{}
+	rc.QueryReturns(openapi.QueryResponse{}, errors.New("query failed"))
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{
+		QueryText:       "SELECT * FROM users WHERE email = 'jane@example.com' AND id = 'it''s' -- 'comment'",
+		QueryParamStart: ":startTime",
+	}}
+	pCtx := fakePluginContext()
+	req := &backend.QueryDataRequest{
+		PluginContext: pCtx,
+		Queries: []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm),
+			TimeRange: backend.TimeRange{From: time.Unix(0, 0), To: time.Unix(60, 0)}}},
+	}
+
+	_, err := ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+	entry := logger.find("executing query")
+	require.NotNil(t, entry)
+	assert.Equal(t, "debug", entry.level)
+	assert.Equal(t, qm.QueryText, entry.args["SQL"])
+	assert.Equal(t, "1970-01-01T00:00:00Z", entry.args[":startTime"])
+
+	logger.entries = nil
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"server":"api.usw2a1.rockset.com",` +
+		`"logLevel":"info","redactLogs":true}`)
+	req.PluginContext = pCtx
+	_, err = ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+	entry = logger.find("executing query")
+	require.NotNil(t, entry)
+	assert.Equal(t, "info", entry.level)
+	assert.Equal(t, "SELECT * FROM users WHERE email = '?' AND id = '?' -- 'comment'", entry.args["SQL"])
+	assert.Equal(t, "?", entry.args[":startTime"])
+	entry = logger.find("query")
+	require.NotNil(t, entry)
+	assert.NotContains(t, entry.args["JSON"], "jane@example.com")
+
+	logger.entries = nil
+	pCtx.DataSourceInstanceSettings.JSONData = []byte(`{"server":"api.usw2a1.rockset.com","logSqlOnError":true}`)
+	req.PluginContext = pCtx
+	_, err = ds.QueryData(context.Background(), req)
+	require.NoError(t, err)
+	assert.Nil(t, logger.find("executing query"))
+	assert.Nil(t, logger.find("query"))
+	entry = logger.find("query failed")
+	require.NotNil(t, entry)
+	assert.Equal(t, "error", entry.level)
+	assert.Equal(t, qm.QueryText, entry.args["SQL"])
+}
+
+func TestStreamSubscription(t *testing.T) {
+	qr := openapi.QueryResponse{
+		Results:      prepareTestData(t, []testType{{Time: "2024-01-23T19:25:17.000000-08:00", V1: 1.111}}),
+		ColumnFields: []openapi.QueryFieldType{{Name: "time"}, {Name: "v1"}},
+		Stats:        &openapi.QueryResponseStats{},
+	}
+
+	rc := fake.FakeRockClient{}
+	rc.QueryReturns(qr, nil)
+
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &rc, nil
+		},
+	}
+
+	qm := plugin.MetricsQueryModel{
+		QueryModel: plugin.QueryModel{QueryTimeField: "time"},
+		Stream:     true,
+	}
+
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		PluginContext: fakePluginContext(),
+		Queries:       []backend.DataQuery{{RefID: "A", JSON: marshal(t, qm)}},
+	})
+	require.NoError(t, err)
+	frames := resp.Responses["A"].Frames
+	require.Len(t, frames, 1)
+
+	channel, err := live.ParseChannel(frames[0].Meta.Channel)
+	require.NoError(t, err)
+	assert.Equal(t, "rockset", channel.Namespace)
+
+	sub, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+		PluginContext: fakePluginContext(),
+		Path:          channel.Path,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusOK, sub.Status)
+
+	sub, err = ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+		PluginContext: fakePluginContext(),
+		Path:          "tail/unknown",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, backend.SubscribeStreamStatusNotFound, sub.Status)
+}
+
+func marshal(t testing.TB, v interface{}) []byte {
 	t.Helper()
 
 	b, err := json.Marshal(v)
@@ -122,6 +1883,10 @@ func TestHealthCheck(t *testing.T) {
 	f.GetOrganizationReturns(openapi.Organization{
 		Id: openapi.PtrString("org"),
 	}, nil)
+	f.GetVirtualInstanceReturns(openapi.VirtualInstance{
+		Name:  "vi",
+		State: openapi.PtrString("ACTIVE"),
+	}, nil)
 	ds := plugin.RocksetDatasource{
 		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
 			return &f, nil
@@ -134,13 +1899,184 @@ func TestHealthCheck(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, resp.Status, backend.HealthStatusOk)
 	assert.Equal(t, "Rockset datasource is working, connected to org", resp.Message)
+	assert.Equal(t, 1, f.QueryCallCount())
+}
+
+func TestHealthCheckWithoutOrgPermission(t *testing.T) {
+	ctx := context.TODO()
+	f := fake.FakeRockClient{}
+	f.GetOrganizationReturns(openapi.Organization{}, errors.New("missing GET_ORG_GLOBAL"))
+	f.GetVirtualInstanceReturns(openapi.VirtualInstance{
+		Name:  "vi",
+		State: openapi.PtrString("SUSPENDED"),
+	}, nil)
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &f, nil
+		},
+	}
+
+	resp, err := ds.CheckHealth(ctx, &backend.CheckHealthRequest{
+		PluginContext: fakePluginContext(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusError, resp.Status)
+	assert.Equal(t, "virtual instance check failed: virtual instance vi is SUSPENDED, not ACTIVE", resp.Message)
+
+	var details plugin.HealthDetails
+	require.NoError(t, json.Unmarshal(resp.JSONDetails, &details))
+	require.Len(t, details.Steps, 2)
+	assert.Equal(t, plugin.HealthStepWarning, details.Steps[0].Status)
+	assert.Equal(t, plugin.HealthStepError, details.Steps[1].Status)
+
+	f.GetVirtualInstanceReturns(openapi.VirtualInstance{
+		Name:  "vi",
+		State: openapi.PtrString("ACTIVE"),
+	}, nil)
+	resp, err = ds.CheckHealth(ctx, &backend.CheckHealthRequest{
+		PluginContext: fakePluginContext(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusOk, resp.Status)
+	require.NoError(t, json.Unmarshal(resp.JSONDetails, &details))
+	require.Len(t, details.Steps, 5)
+	assert.Equal(t, "query", details.Steps[2].Name)
+	assert.Equal(t, plugin.HealthStepOK, details.Steps[2].Status)
+}
+
+func TestHealthCheckMissingPermissions(t *testing.T) {
+	ctx := context.TODO()
+	f := fake.FakeRockClient{}
+	f.GetOrganizationReturns(openapi.Organization{Id: openapi.PtrString("org")}, nil)
+	f.GetVirtualInstanceReturns(openapi.VirtualInstance{Name: "vi", State: openapi.PtrString("ACTIVE")}, nil)
+	f.GetCurrentUserReturns(openapi.User{Email: "user@example.com", Roles: []string{"member"}}, nil)
+	f.ListAPIKeysReturns([]openapi.ApiKey{
+		{Name: "other", Key: "xyz***"},
+		{Name: "grafana", Key: "foo***", Role: openapi.PtrString("grafana")},
+	}, nil)
+	f.GetRoleReturns(openapi.Role{
+		RoleName: openapi.PtrString("grafana"),
+		Privileges: []openapi.Privilege{
+			{Action: openapi.PtrString("QUERY_DATA_WS")},
+			{Action: openapi.PtrString("ALL_VI_ACTIONS")},
+			{Action: openapi.PtrString("ALL_GLOBAL_ACTIONS")},
+		},
+	}, nil)
+	ds := plugin.RocksetDatasource{
+		ClientFactory: func(option ...rockset.RockOption) (plugin.RockClient, error) {
+			return &f, nil
+		},
+	}
+
+	resp, err := ds.CheckHealth(ctx, &backend.CheckHealthRequest{
+		PluginContext: fakePluginContext(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, backend.HealthStatusOk, resp.Status)
+	assert.Equal(t, "Rockset datasource is working, connected to org, but the API key is not allowed to "+
+		"execute query lambdas (EXECUTE_QUERY_LAMBDA_WS), "+
+		"list collections and query lambdas in workspaces (LIST_RESOURCES_WS)", resp.Message)
+	require.Equal(t, 1, f.GetRoleCallCount())
+	_, role := f.GetRoleArgsForCall(0)
+	assert.Equal(t, "grafana", role)
 }
 
 func fakePluginContext() backend.PluginContext {
 	return backend.PluginContext{
 		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{
+			UID:                     "rockset",
 			DecryptedSecureJSONData: map[string]string{"apiKey": "foobar"},
 			JSONData:                []byte(`{"server":"api.usw2a1.rockset.com","vi":"vi"}`),
 		},
 	}
 }
+
+func BenchmarkMetricsQuery(b *testing.B) {
+	defaultLogger := log.DefaultLogger
+	log.DefaultLogger = log.NewWithLevel(log.Error)
+	b.Cleanup(func() { log.DefaultLogger = defaultLogger })
+
+	for _, bc := range []struct {
+		rows, series int
+	}{
+		{rows: 10_000, series: 100},
+		{rows: 100_000, series: 1_000},
+	} {
+		b.Run(fmt.Sprintf("%d rows %d series", bc.rows, bc.series), func(b *testing.B) {
+			start := time.Date(2024, 1, 23, 0, 0, 0, 0, time.UTC)
+			results := make([]map[string]interface{}, bc.rows)
+			for i := range results {
+				results[i] = map[string]interface{}{
+					"time":   start.Add(time.Duration(i/bc.series) * time.Second).Format(time.RFC3339Nano),
+					"host":   fmt.Sprintf("host-%d", i%bc.series),
+					"v1":     float64(i),
+					"status": "ok",
+				}
+			}
+
+			rc := fake.FakeRockClient{}
+			rc.QueryReturns(openapi.QueryResponse{
+				Results: results,
+				ColumnFields: []openapi.QueryFieldType{
+					{Name: "time"}, {Name: "host"}, {Name: "v1"}, {Name: "status"},
+				},
+				Stats: &openapi.QueryResponseStats{},
+			}, nil)
+
+			qm := plugin.MetricsQueryModel{QueryModel: plugin.QueryModel{QueryTimeField: "time", QueryText: "SELECT 1"},
+				QueryLabelColumn: "host"}
+			query := backend.DataQuery{RefID: "A", JSON: marshal(b, qm)}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res := plugin.MetricsQuery(context.Background(), &rc, "", query)
+				if res.Error != nil {
+					b.Fatal(res.Error)
+				}
+				if len(res.Frames) != bc.series {
+					b.Fatalf("expected %d frames, got %d", bc.series, len(res.Frames))
+				}
+			}
+		})
+	}
+}
+
+type logEntry struct {
+	level string
+	msg   string
+	args  map[string]any
+}
+
+// recordingLogger records the log entries, so tests can verify what is logged
+type recordingLogger struct {
+	entries []logEntry
+}
+
+func (l *recordingLogger) record(level, msg string, args []any) {
+	e := logEntry{level: level, msg: msg, args: make(map[string]any)}
+	for i := 0; i+1 < len(args); i += 2 {
+		e.args[fmt.Sprint(args[i])] = args[i+1]
+	}
+	l.entries = append(l.entries, e)
+}
+
+func (l *recordingLogger) find(msg string) *logEntry {
+	for i := range l.entries {
+		if l.entries[i].msg == msg {
+			return &l.entries[i]
+		}
+	}
+
+	return nil
+}
+
+func (l *recordingLogger) Debug(msg string, args ...any) { l.record("debug", msg, args) }
+func (l *recordingLogger) Info(msg string, args ...any)  { l.record("info", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...any)  { l.record("warn", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...any) { l.record("error", msg, args) }
+func (l *recordingLogger) With(...any) log.Logger        { return l }
+func (l *recordingLogger) Level() log.Level              { return log.Debug }
+func (l *recordingLogger) FromContext(context.Context) log.Logger {
+	return l
+}
@@ -0,0 +1,310 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/rockset/rockset-go-client"
+	rockerr "github.com/rockset/rockset-go-client/errors"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// rowStream receives the rows of a query as they are decoded from the response, so they can be appended to
+// the series without first decoding all rows into a slice of maps
+type rowStream struct {
+	appendRow func(row map[string]interface{}) error
+
+	// rows is the number of rows appended
+	rows int
+	// streamed is set when the rows were appended while decoding the response, and aren't in the Results
+	streamed bool
+	// truncated is set when the response was larger than the limit, so the remaining rows were dropped
+	truncated bool
+	// limit is the number of bytes the response was truncated at
+	limit int64
+	// read counts the bytes read by all the streams of a query which is split into chunks, so the limit applies
+	// to their combined responses. When it is nil, the limit applies to the response of this stream.
+	read *atomic.Int64
+	// maxRows is the most rows which are appended, where 0 is no limit
+	maxRows int
+	// limited is set when there were more rows than maxRows, so the remaining rows were dropped
+	limited bool
+	// err is the error returned by appendRow, which stops the decoding
+	err error
+}
+
+type rowStreamKey struct{}
+
+// withRowStream returns a context which asks the RockClient to stream the rows of the query to s
+func withRowStream(ctx context.Context, s *rowStream) context.Context {
+	return context.WithValue(ctx, rowStreamKey{}, s)
+}
+
+// rowStreamFrom returns the rowStream of the context, or nil if the rows shouldn't be streamed
+func rowStreamFrom(ctx context.Context) *rowStream {
+	s, _ := ctx.Value(rowStreamKey{}).(*rowStream)
+	return s
+}
+
+func (s *rowStream) append(row map[string]interface{}) error {
+	if s.maxRows > 0 && s.rows >= s.maxRows {
+		s.limited = true
+		return nil
+	}
+	if err := s.appendRow(row); err != nil {
+		s.err = err
+		return err
+	}
+	s.rows++
+
+	return nil
+}
+
+// fill appends the Results of the query response, unless the rows already were appended while streaming
+func (s *rowStream) fill(qr openapi.QueryResponse) error {
+	if s.streamed {
+		return nil
+	}
+
+	for _, row := range qr.Results {
+		if err := s.append(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// truncatedNotice tells that the response was truncated after rows, as it exceeded the limit of bytes
+func truncatedNotice(rows int, limit int64) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("the response was truncated after %d rows, as it exceeded the limit of %d bytes", rows, limit),
+	}
+}
+
+// resultRows returns the number of rows in the result, which for streamed responses isn't the length of Results
+func resultRows(qr openapi.QueryResponse) int {
+	if len(qr.Results) > 0 {
+		return len(qr.Results)
+	}
+
+	return int(qr.GetResultsTotalDocCount())
+}
+
+// streamingClient is a RockClient which decodes the rows of the query response one at a time, when the context
+// has a rowStream, instead of letting the Rockset client decode the whole response
+type streamingClient struct {
+	RockClient
+	client   *rockset.RockClient
+	maxBytes int64
+}
+
+// withStreaming wraps the RockClient so query responses are streamed, and stops decoding the response after
+// maxBytes, unless it is 0. Only the Rockset client can be wrapped, as the requests are sent using its configuration.
+func withStreaming(rs RockClient, maxBytes int64) RockClient {
+	client, ok := rs.(*rockset.RockClient)
+	if !ok {
+		return rs
+	}
+
+	return &streamingClient{RockClient: rs, client: client, maxBytes: maxBytes}
+}
+
+// Query sends the same request as the Rockset client, but decodes the rows of the response as they are read
+func (c *streamingClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	stream := rowStreamFrom(ctx)
+	if stream == nil {
+		return c.RockClient.Query(ctx, sql, options...)
+	}
+
+	request := applyQueryOptions(sql, options)
+	body, err := json.Marshal(request.QueryRequest)
+	if err != nil {
+		return openapi.QueryResponse{}, fmt.Errorf("failed to marshal query request: %w", err)
+	}
+
+	cfg := c.client.GetConfig()
+	u := url.URL{Scheme: cfg.Scheme, Host: cfg.Host, Path: "/v1/orgs/self/queries"}
+	if request.VirtualInstance != nil {
+		u.Path = "/v1/orgs/self/virtualinstances/" + url.PathEscape(*request.VirtualInstance) + "/queries"
+	}
+
+	var resp *http.Response
+	err = c.client.Retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for k, v := range cfg.DefaultHeader {
+			req.Header.Set(k, v)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("User-Agent", cfg.UserAgent)
+
+		resp, err = cfg.HTTPClient.Do(req)
+		if err != nil {
+			return rockerr.New(err)
+		}
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			defer resp.Body.Close()
+			return responseError(resp)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return openapi.QueryResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	return decodeQueryResponse(resp.Body, stream, c.maxBytes)
+}
+
+// maxErrorBytes is the most of an error response which is read
+const maxErrorBytes = 1 << 20
+
+// responseError returns the error of a failed request, using the error model in the response if there is one
+func responseError(resp *http.Response) error {
+	re := rockerr.Error{
+		Cause:      fmt.Errorf("query failed: %s", resp.Status),
+		StatusCode: resp.StatusCode,
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBytes))
+	if err != nil {
+		return re
+	}
+	var model openapi.ErrorModel
+	if err = json.Unmarshal(b, &model); err == nil && (model.Message != nil || model.Type != nil) {
+		re.ErrorModel = &model
+	}
+
+	return re
+}
+
+// countingReader counts the bytes read, and adds them to shared unless it is nil
+type countingReader struct {
+	r      io.Reader
+	n      int64
+	shared *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.shared != nil {
+		r.shared.Add(int64(n))
+	}
+	return n, err
+}
+
+// read returns the bytes read, which are the bytes read by all readers sharing the count if there is one
+func (r *countingReader) read() int64 {
+	if r.shared != nil {
+		return r.shared.Load()
+	}
+
+	return r.n
+}
+
+// decodeQueryResponse decodes the query response, appending each row of the results to the stream as it is
+// decoded, and reusing the same map for every row. All other fields of the response are returned.
+// If more than maxBytes are read, the remaining rows are skipped, and the stream is marked as truncated.
+func decodeQueryResponse(r io.Reader, stream *rowStream, maxBytes int64) (openapi.QueryResponse, error) {
+	var qr openapi.QueryResponse
+
+	cr := &countingReader{r: r, shared: stream.read}
+	dec := json.NewDecoder(cr)
+	if err := expectDelim(dec, '{'); err != nil {
+		return qr, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return qr, fmt.Errorf("failed to decode query response: %w", err)
+		}
+		key, ok := t.(string)
+		if !ok {
+			return qr, fmt.Errorf("failed to decode query response: unexpected %v", t)
+		}
+
+		if key != "results" {
+			var raw json.RawMessage
+			if err = dec.Decode(&raw); err != nil {
+				return qr, fmt.Errorf("failed to decode query response field %s: %w", key, err)
+			}
+			fields[key] = raw
+			continue
+		}
+
+		if err = expectDelim(dec, '['); err != nil {
+			return qr, err
+		}
+		row := make(map[string]interface{})
+		for dec.More() {
+			if !stream.truncated && maxBytes > 0 && cr.read() > maxBytes {
+				stream.truncated = true
+				stream.limit = maxBytes
+			}
+			// the rows after the limit are skipped, so the fields after the results, like the column fields
+			// and the stats, are still decoded
+			if stream.truncated {
+				var skipped json.RawMessage
+				if err = dec.Decode(&skipped); err != nil {
+					return qr, fmt.Errorf("failed to decode query result: %w", err)
+				}
+				continue
+			}
+
+			clear(row)
+			if err = dec.Decode(&row); err != nil {
+				return qr, fmt.Errorf("failed to decode query result: %w", err)
+			}
+			if err = stream.append(row); err != nil {
+				return qr, err
+			}
+		}
+		if err = expectDelim(dec, ']'); err != nil {
+			return qr, err
+		}
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return qr, fmt.Errorf("failed to decode query response: %w", err)
+	}
+	if err = json.Unmarshal(b, &qr); err != nil {
+		return qr, fmt.Errorf("failed to decode query response: %w", err)
+	}
+
+	stream.streamed = true
+	if !qr.HasResultsTotalDocCount() || stream.truncated {
+		qr.SetResultsTotalDocCount(int64(stream.rows))
+	}
+
+	return qr, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to decode query response: %w", err)
+	}
+	if t != delim {
+		return fmt.Errorf("failed to decode query response: expected %v but got %v", delim, t)
+	}
+
+	return nil
+}
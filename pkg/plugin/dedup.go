@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// queryGroup collapses identical queries which are in flight at the same time into a single query to Rockset,
+// like singleflight, but the shared query is only cancelled once every caller has given up on it.
+// The zero value is an empty group.
+type queryGroup struct {
+	mu    sync.Mutex
+	calls map[string]*queryCall
+}
+
+// queryCall is a query in flight, which is shared by all callers waiting for it
+type queryCall struct {
+	done    chan struct{}
+	qr      openapi.QueryResponse
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do runs fn unless a query with the same key is already in flight, in which case it waits for that query.
+// fn is run with a context which isn't cancelled when ctx is, but only once all callers' contexts are.
+// The returned bool is set when the response of another caller's query is returned.
+func (g *queryGroup) do(ctx context.Context, key string,
+	fn func(context.Context) (openapi.QueryResponse, error)) (openapi.QueryResponse, error, bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*queryCall)
+	}
+	c, shared := g.calls[key]
+	if !shared {
+		qctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &queryCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+
+		go func() {
+			c.qr, c.err = fn(qctx)
+			g.forget(key, c)
+			cancel()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.qr, c.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// nobody is waiting for the query anymore, so new callers get a new query
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+
+		return openapi.QueryResponse{}, ctx.Err(), shared
+	}
+}
+
+// forget removes the call, unless it already has been replaced by a new call with the same key
+func (g *queryGroup) forget(key string, c *queryCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
+
+// dedupClient is a RockClient which shares the response of identical queries which are in flight at the same time
+type dedupClient struct {
+	RockClient
+	group  *queryGroup
+	uid    string
+	labels prometheus.Labels
+}
+
+// withDeduplication wraps the RockClient so identical queries to the datasource, which are in flight at the
+// same time, are only sent to Rockset once. It needs to wrap the RockClient before the query context is added,
+// so queries from different panels and users are identical.
+func withDeduplication(rs RockClient, group *queryGroup, queryType, datasourceUID string) RockClient {
+	return &dedupClient{
+		RockClient: rs,
+		group:      group,
+		uid:        datasourceUID,
+		labels:     prometheus.Labels{"query_type": queryType, "datasource_uid": datasourceUID},
+	}
+}
+
+// Query executes the query, or waits for the identical query which is already in flight
+func (c *dedupClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	request := applyQueryOptions(sql, options)
+	b, err := json.Marshal(struct {
+		UID     string                `json:"uid"`
+		VI      *string               `json:"vi"`
+		Request *openapi.QueryRequest `json:"request"`
+	}{c.uid, request.VirtualInstance, request.QueryRequest})
+	if err != nil {
+		return c.RockClient.Query(ctx, sql, options...)
+	}
+	sum := sha256.Sum256(b)
+
+	qr, err, shared := c.group.do(ctx, hex.EncodeToString(sum[:]), func(ctx context.Context) (openapi.QueryResponse, error) {
+		// the rows of a shared response can't be streamed into the series of a single caller
+		return c.RockClient.Query(withRowStream(ctx, nil), sql, options...)
+	})
+	if shared {
+		deduplicatedQueries.With(c.labels).Inc()
+	}
+
+	return qr, err
+}
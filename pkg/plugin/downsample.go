@@ -0,0 +1,230 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// DownsampleLTTB keeps the rows which best preserve the shape of the series, using Largest-Triangle-Three-Buckets
+	DownsampleLTTB = "lttb"
+	// DownsampleAverage averages the values of each time bucket
+	DownsampleAverage = "avg"
+	// DownsampleMinMax keeps the rows with the smallest and largest value of each time bucket
+	DownsampleMinMax = "minmax"
+)
+
+func validDownsample(mode string) bool {
+	switch mode {
+	case "", DownsampleLTTB, DownsampleAverage, DownsampleMinMax:
+		return true
+	default:
+		return false
+	}
+}
+
+// downsampleSeries downsamples each series with more rows than maxPoints, and returns how many were downsampled
+func downsampleSeries(ss [][]*data.Field, mode string, maxPoints int) ([][]*data.Field, int) {
+	if mode == "" || maxPoints <= 0 {
+		return ss, 0
+	}
+
+	downsampled := 0
+	for i, fields := range ss {
+		var ok bool
+		if ss[i], ok = downsample(fields, mode, maxPoints); ok {
+			downsampled++
+		}
+	}
+
+	return ss, downsampled
+}
+
+// downsampleNotice tells how many of the series were downsampled
+func downsampleNotice(downsampled, series, maxPoints int, mode string) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("%d of %d series were downsampled to %d points using %s", downsampled, series, maxPoints, mode),
+	}
+}
+
+// downsample reduces the rows of the series to at most maxPoints, sorted by time. The rows are picked by the first
+// number column of the series, and when it has none they are picked evenly. It returns false when the series
+// has no time column, or already has few enough rows.
+func downsample(fields []*data.Field, mode string, maxPoints int) ([]*data.Field, bool) {
+	timeIndex := timeField(fields)
+	if timeIndex == -1 || fields[timeIndex].Len() <= maxPoints {
+		return fields, false
+	}
+	order := timeOrder(fields[timeIndex])
+
+	if mode == DownsampleAverage {
+		return average(fields, order, maxPoints), true
+	}
+
+	valueIndex := -1
+	for i, f := range fields {
+		if f.Type() == data.FieldTypeNullableFloat64 {
+			valueIndex = i
+			break
+		}
+	}
+
+	var picked []int
+	switch {
+	case valueIndex == -1 || maxPoints < 3:
+		picked = evenly(len(order), maxPoints)
+	case mode == DownsampleMinMax:
+		picked = minMax(fields[valueIndex], order, maxPoints/2)
+	default:
+		picked = lttb(fields[timeIndex], fields[valueIndex], order, maxPoints)
+	}
+
+	out := emptyFields(fields)
+	for _, p := range picked {
+		for j, f := range fields {
+			out[j].Append(f.At(order[p]))
+		}
+	}
+
+	return out, true
+}
+
+// bucket returns the first and one past the last row of bucket k, when n rows are split into buckets
+func bucket(n, buckets, k int) (int, int) {
+	return k * n / buckets, (k + 1) * n / buckets
+}
+
+// evenly picks the first row of each bucket
+func evenly(n, buckets int) []int {
+	picked := make([]int, 0, buckets)
+	for k := 0; k < buckets; k++ {
+		start, _ := bucket(n, buckets, k)
+		picked = append(picked, start)
+	}
+
+	return picked
+}
+
+// floatAt returns the value of the number field at row i, where null is NaN
+func floatAt(f *data.Field, i int) float64 {
+	if v, ok := f.At(i).(*float64); ok && v != nil {
+		return *v
+	}
+
+	return math.NaN()
+}
+
+// minMax picks the rows with the smallest and the largest value of each bucket, in the order of time
+func minMax(values *data.Field, order []int, buckets int) []int {
+	picked := make([]int, 0, 2*buckets)
+	for k := 0; k < buckets; k++ {
+		start, end := bucket(len(order), buckets, k)
+		lo, hi := start, start
+		for p := start; p < end; p++ {
+			v := floatAt(values, order[p])
+			if math.IsNaN(v) {
+				continue
+			}
+			if lv := floatAt(values, order[lo]); math.IsNaN(lv) || v < lv {
+				lo = p
+			}
+			if hv := floatAt(values, order[hi]); math.IsNaN(hv) || v > hv {
+				hi = p
+			}
+		}
+		picked = append(picked, min(lo, hi))
+		if lo != hi {
+			picked = append(picked, max(lo, hi))
+		}
+	}
+
+	return picked
+}
+
+// lttb picks maxPoints rows using Largest-Triangle-Three-Buckets, which always keeps the first and last row,
+// and from each bucket in between the row which forms the largest triangle with the previous picked row and
+// the average of the next bucket. Rows with a null value are only picked when the bucket has no values.
+func lttb(times, values *data.Field, order []int, maxPoints int) []int {
+	n := len(order)
+	x := make([]float64, n)
+	y := make([]float64, n)
+	for p, i := range order {
+		x[p] = float64(times.At(i).(time.Time).UnixMilli())
+		y[p] = floatAt(values, i)
+	}
+
+	picked := make([]int, 0, maxPoints)
+	picked = append(picked, 0)
+	every := float64(n-2) / float64(maxPoints-2)
+	a := 0
+	for k := 0; k < maxPoints-2; k++ {
+		// the average of the next bucket, which for the last bucket is the last row
+		avgStart := int(float64(k+1)*every) + 1
+		avgEnd := min(int(float64(k+2)*every)+1, n)
+		var avgX, avgY float64
+		count := 0
+		for p := avgStart; p < avgEnd; p++ {
+			if !math.IsNaN(y[p]) {
+				avgX += x[p]
+				avgY += y[p]
+				count++
+			}
+		}
+		if count > 0 {
+			avgX /= float64(count)
+			avgY /= float64(count)
+		} else {
+			avgX, avgY = x[n-1], y[n-1]
+		}
+
+		start := int(float64(k)*every) + 1
+		end := int(float64(k+1)*every) + 1
+		next, largest := start, -1.0
+		for p := start; p < end; p++ {
+			area := math.Abs((x[a]-avgX)*(y[p]-y[a]) - (x[a]-x[p])*(avgY-y[a]))
+			if area > largest {
+				next, largest = p, area
+			}
+		}
+		picked = append(picked, next)
+		a = next
+	}
+
+	return append(picked, n-1)
+}
+
+// average returns a row for each bucket, with the time of the first row of the bucket, the average of the values
+// of each number column, and the first value of the other columns
+func average(fields []*data.Field, order []int, buckets int) []*data.Field {
+	out := emptyFields(fields)
+	for k := 0; k < buckets; k++ {
+		start, end := bucket(len(order), buckets, k)
+		for j, f := range fields {
+			if f.Type() != data.FieldTypeNullableFloat64 {
+				out[j].Append(f.At(order[start]))
+				continue
+			}
+
+			var sum float64
+			count := 0
+			for p := start; p < end; p++ {
+				if v := floatAt(f, order[p]); !math.IsNaN(v) {
+					sum += v
+					count++
+				}
+			}
+			if count == 0 {
+				out[j].Append((*float64)(nil))
+				continue
+			}
+			avg := sum / float64(count)
+			out[j].Append(&avg)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	rockerr "github.com/rockset/rockset-go-client/errors"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// errorTypeStatus maps the type of Rockset errors to the Grafana status
+var errorTypeStatus = map[string]backend.Status{
+	"AUTHEXCEPTION":           backend.StatusUnauthorized,
+	"FORBIDDEN":               backend.StatusForbidden,
+	"NOTALLOWED":              backend.StatusForbidden,
+	"INVALIDINPUT":            backend.StatusBadRequest,
+	"QUERY_ERROR":             backend.StatusBadRequest,
+	"BADREQUEST":              backend.StatusBadRequest,
+	"NOTACCEPTABLE":           backend.StatusBadRequest,
+	"NOTSUPPORTED":            backend.StatusBadRequest,
+	"VERSIONEXCEPTION":        backend.StatusBadRequest,
+	"CONTENTTOOLARGE":         backend.StatusBadRequest,
+	"ALREADYEXISTS":           backend.StatusBadRequest,
+	"CONFLICT":                backend.StatusBadRequest,
+	"DEPENDENTRESOURCES":      backend.StatusBadRequest,
+	"NOTIMPLEMENTEDYET":       backend.StatusNotImplemented,
+	"NOTFOUND":                backend.StatusNotFound,
+	"RESOURCEEXCEEDED":        backend.StatusTooManyRequests,
+	"RATELIMITEXCEEDED":       backend.StatusTooManyRequests,
+	"QUERY_TIMEOUT":           backend.StatusTimeout,
+	"QUERY_CANCELLED":         backend.StatusTimeout,
+	"INTERNALERROR":           backend.StatusBadGateway,
+	"SERVICEUNAVAILABLE":      backend.StatusBadGateway,
+	"CONNECTION_ERROR":        backend.StatusBadGateway,
+	"CLIENT_CONNECTION_ERROR": backend.StatusBadGateway,
+	"NOT_READY":               backend.StatusBadGateway,
+	"CREATING":                backend.StatusBadGateway,
+}
+
+// errorStatus returns the Grafana status of the error and whether it is caused by Rockset, which includes
+// errors in the SQL as well as Rockset being unavailable, or by the plugin
+func errorStatus(err error) (backend.Status, backend.ErrorSource) {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return backend.StatusTimeout, backend.ErrorSourceDownstream
+	}
+
+	// a blocked query is a problem with the query rather than the plugin
+	var we *writeError
+	var ce *collectionError
+	if errors.As(err, &we) || errors.As(err, &ce) {
+		return backend.StatusForbidden, backend.ErrorSourceDownstream
+	}
+
+	var re rockerr.Error
+	if !errors.As(err, &re) {
+		return backend.StatusInternal, backend.ErrorSourcePlugin
+	}
+
+	if re.ErrorModel != nil {
+		if status, found := errorTypeStatus[re.GetType()]; found {
+			return status, backend.ErrorSourceDownstream
+		}
+	}
+
+	return httpStatus(re.StatusCode), backend.ErrorSourceDownstream
+}
+
+// httpStatus maps the HTTP status code of Rockset errors without a known type to the Grafana status
+func httpStatus(statusCode int) backend.Status {
+	switch {
+	case statusCode == 0:
+		// the request never got a response
+		return backend.StatusBadGateway
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusGatewayTimeout:
+		return backend.StatusTimeout
+	case statusCode == http.StatusUnauthorized, statusCode == http.StatusForbidden,
+		statusCode == http.StatusNotFound, statusCode == http.StatusTooManyRequests:
+		return backend.Status(statusCode)
+	case statusCode >= 500:
+		return backend.StatusBadGateway
+	case statusCode >= 400:
+		return backend.StatusBadRequest
+	default:
+		return backend.StatusUnknown
+	}
+}
+
+// sqlError is an error from executing a query, together with the SQL as it was sent to Rockset
+type sqlError struct {
+	sql string
+	err error
+}
+
+func (e *sqlError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sqlError) Unwrap() error {
+	return e.err
+}
+
+// sqlErrorClient is a RockClient which adds the SQL to the errors of queries
+type sqlErrorClient struct {
+	RockClient
+}
+
+// withSQLErrors wraps the RockClient so errors of queries include the SQL, which needs to be the innermost
+// RockClient, so it sees the SQL after all other clients have modified it
+func withSQLErrors(rs RockClient) RockClient {
+	return &sqlErrorClient{RockClient: rs}
+}
+
+// Query executes the query and wraps any error in a sqlError
+func (c *sqlErrorClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	qr, err := c.RockClient.Query(ctx, sql, options...)
+	if err != nil {
+		return qr, &sqlError{sql: sql, err: err}
+	}
+
+	return qr, nil
+}
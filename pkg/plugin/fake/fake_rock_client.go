@@ -11,6 +11,19 @@ import (
 )
 
 type FakeRockClient struct {
+	GetCurrentUserStub        func(context.Context) (openapi.User, error)
+	getCurrentUserMutex       sync.RWMutex
+	getCurrentUserArgsForCall []struct {
+		arg1 context.Context
+	}
+	getCurrentUserReturns struct {
+		result1 openapi.User
+		result2 error
+	}
+	getCurrentUserReturnsOnCall map[int]struct {
+		result1 openapi.User
+		result2 error
+	}
 	GetOrganizationStub        func(context.Context) (openapi.Organization, error)
 	getOrganizationMutex       sync.RWMutex
 	getOrganizationArgsForCall []struct {
@@ -24,6 +37,62 @@ type FakeRockClient struct {
 		result1 openapi.Organization
 		result2 error
 	}
+	GetQueryInfoStub        func(context.Context, string) (openapi.QueryInfo, error)
+	getQueryInfoMutex       sync.RWMutex
+	getQueryInfoArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	getQueryInfoReturns struct {
+		result1 openapi.QueryInfo
+		result2 error
+	}
+	getQueryInfoReturnsOnCall map[int]struct {
+		result1 openapi.QueryInfo
+		result2 error
+	}
+	GetRoleStub        func(context.Context, string) (openapi.Role, error)
+	getRoleMutex       sync.RWMutex
+	getRoleArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	getRoleReturns struct {
+		result1 openapi.Role
+		result2 error
+	}
+	getRoleReturnsOnCall map[int]struct {
+		result1 openapi.Role
+		result2 error
+	}
+	GetVirtualInstanceStub        func(context.Context, string) (openapi.VirtualInstance, error)
+	getVirtualInstanceMutex       sync.RWMutex
+	getVirtualInstanceArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+	}
+	getVirtualInstanceReturns struct {
+		result1 openapi.VirtualInstance
+		result2 error
+	}
+	getVirtualInstanceReturnsOnCall map[int]struct {
+		result1 openapi.VirtualInstance
+		result2 error
+	}
+	ListAPIKeysStub        func(context.Context, ...option.APIKeyOption) ([]openapi.ApiKey, error)
+	listAPIKeysMutex       sync.RWMutex
+	listAPIKeysArgsForCall []struct {
+		arg1 context.Context
+		arg2 []option.APIKeyOption
+	}
+	listAPIKeysReturns struct {
+		result1 []openapi.ApiKey
+		result2 error
+	}
+	listAPIKeysReturnsOnCall map[int]struct {
+		result1 []openapi.ApiKey
+		result2 error
+	}
 	QueryStub        func(context.Context, string, ...option.QueryOption) (openapi.QueryResponse, error)
 	queryMutex       sync.RWMutex
 	queryArgsForCall []struct {
@@ -43,6 +112,70 @@ type FakeRockClient struct {
 	invocationsMutex sync.RWMutex
 }
 
+func (fake *FakeRockClient) GetCurrentUser(arg1 context.Context) (openapi.User, error) {
+	fake.getCurrentUserMutex.Lock()
+	ret, specificReturn := fake.getCurrentUserReturnsOnCall[len(fake.getCurrentUserArgsForCall)]
+	fake.getCurrentUserArgsForCall = append(fake.getCurrentUserArgsForCall, struct {
+		arg1 context.Context
+	}{arg1})
+	stub := fake.GetCurrentUserStub
+	fakeReturns := fake.getCurrentUserReturns
+	fake.recordInvocation("GetCurrentUser", []interface{}{arg1})
+	fake.getCurrentUserMutex.Unlock()
+	if stub != nil {
+		return stub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeRockClient) GetCurrentUserCallCount() int {
+	fake.getCurrentUserMutex.RLock()
+	defer fake.getCurrentUserMutex.RUnlock()
+	return len(fake.getCurrentUserArgsForCall)
+}
+
+func (fake *FakeRockClient) GetCurrentUserCalls(stub func(context.Context) (openapi.User, error)) {
+	fake.getCurrentUserMutex.Lock()
+	defer fake.getCurrentUserMutex.Unlock()
+	fake.GetCurrentUserStub = stub
+}
+
+func (fake *FakeRockClient) GetCurrentUserArgsForCall(i int) context.Context {
+	fake.getCurrentUserMutex.RLock()
+	defer fake.getCurrentUserMutex.RUnlock()
+	argsForCall := fake.getCurrentUserArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeRockClient) GetCurrentUserReturns(result1 openapi.User, result2 error) {
+	fake.getCurrentUserMutex.Lock()
+	defer fake.getCurrentUserMutex.Unlock()
+	fake.GetCurrentUserStub = nil
+	fake.getCurrentUserReturns = struct {
+		result1 openapi.User
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeRockClient) GetCurrentUserReturnsOnCall(i int, result1 openapi.User, result2 error) {
+	fake.getCurrentUserMutex.Lock()
+	defer fake.getCurrentUserMutex.Unlock()
+	fake.GetCurrentUserStub = nil
+	if fake.getCurrentUserReturnsOnCall == nil {
+		fake.getCurrentUserReturnsOnCall = make(map[int]struct {
+			result1 openapi.User
+			result2 error
+		})
+	}
+	fake.getCurrentUserReturnsOnCall[i] = struct {
+		result1 openapi.User
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeRockClient) GetOrganization(arg1 context.Context) (openapi.Organization, error) {
 	fake.getOrganizationMutex.Lock()
 	ret, specificReturn := fake.getOrganizationReturnsOnCall[len(fake.getOrganizationArgsForCall)]
@@ -107,6 +240,266 @@ func (fake *FakeRockClient) GetOrganizationReturnsOnCall(i int, result1 openapi.
 	}{result1, result2}
 }
 
+func (fake *FakeRockClient) GetQueryInfo(arg1 context.Context, arg2 string) (openapi.QueryInfo, error) {
+	fake.getQueryInfoMutex.Lock()
+	ret, specificReturn := fake.getQueryInfoReturnsOnCall[len(fake.getQueryInfoArgsForCall)]
+	fake.getQueryInfoArgsForCall = append(fake.getQueryInfoArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.GetQueryInfoStub
+	fakeReturns := fake.getQueryInfoReturns
+	fake.recordInvocation("GetQueryInfo", []interface{}{arg1, arg2})
+	fake.getQueryInfoMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeRockClient) GetQueryInfoCallCount() int {
+	fake.getQueryInfoMutex.RLock()
+	defer fake.getQueryInfoMutex.RUnlock()
+	return len(fake.getQueryInfoArgsForCall)
+}
+
+func (fake *FakeRockClient) GetQueryInfoCalls(stub func(context.Context, string) (openapi.QueryInfo, error)) {
+	fake.getQueryInfoMutex.Lock()
+	defer fake.getQueryInfoMutex.Unlock()
+	fake.GetQueryInfoStub = stub
+}
+
+func (fake *FakeRockClient) GetQueryInfoArgsForCall(i int) (context.Context, string) {
+	fake.getQueryInfoMutex.RLock()
+	defer fake.getQueryInfoMutex.RUnlock()
+	argsForCall := fake.getQueryInfoArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeRockClient) GetQueryInfoReturns(result1 openapi.QueryInfo, result2 error) {
+	fake.getQueryInfoMutex.Lock()
+	defer fake.getQueryInfoMutex.Unlock()
+	fake.GetQueryInfoStub = nil
+	fake.getQueryInfoReturns = struct {
+		result1 openapi.QueryInfo
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeRockClient) GetQueryInfoReturnsOnCall(i int, result1 openapi.QueryInfo, result2 error) {
+	fake.getQueryInfoMutex.Lock()
+	defer fake.getQueryInfoMutex.Unlock()
+	fake.GetQueryInfoStub = nil
+	if fake.getQueryInfoReturnsOnCall == nil {
+		fake.getQueryInfoReturnsOnCall = make(map[int]struct {
+			result1 openapi.QueryInfo
+			result2 error
+		})
+	}
+	fake.getQueryInfoReturnsOnCall[i] = struct {
+		result1 openapi.QueryInfo
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeRockClient) GetRole(arg1 context.Context, arg2 string) (openapi.Role, error) {
+	fake.getRoleMutex.Lock()
+	ret, specificReturn := fake.getRoleReturnsOnCall[len(fake.getRoleArgsForCall)]
+	fake.getRoleArgsForCall = append(fake.getRoleArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.GetRoleStub
+	fakeReturns := fake.getRoleReturns
+	fake.recordInvocation("GetRole", []interface{}{arg1, arg2})
+	fake.getRoleMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeRockClient) GetRoleCallCount() int {
+	fake.getRoleMutex.RLock()
+	defer fake.getRoleMutex.RUnlock()
+	return len(fake.getRoleArgsForCall)
+}
+
+func (fake *FakeRockClient) GetRoleCalls(stub func(context.Context, string) (openapi.Role, error)) {
+	fake.getRoleMutex.Lock()
+	defer fake.getRoleMutex.Unlock()
+	fake.GetRoleStub = stub
+}
+
+func (fake *FakeRockClient) GetRoleArgsForCall(i int) (context.Context, string) {
+	fake.getRoleMutex.RLock()
+	defer fake.getRoleMutex.RUnlock()
+	argsForCall := fake.getRoleArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeRockClient) GetRoleReturns(result1 openapi.Role, result2 error) {
+	fake.getRoleMutex.Lock()
+	defer fake.getRoleMutex.Unlock()
+	fake.GetRoleStub = nil
+	fake.getRoleReturns = struct {
+		result1 openapi.Role
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeRockClient) GetRoleReturnsOnCall(i int, result1 openapi.Role, result2 error) {
+	fake.getRoleMutex.Lock()
+	defer fake.getRoleMutex.Unlock()
+	fake.GetRoleStub = nil
+	if fake.getRoleReturnsOnCall == nil {
+		fake.getRoleReturnsOnCall = make(map[int]struct {
+			result1 openapi.Role
+			result2 error
+		})
+	}
+	fake.getRoleReturnsOnCall[i] = struct {
+		result1 openapi.Role
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeRockClient) GetVirtualInstance(arg1 context.Context, arg2 string) (openapi.VirtualInstance, error) {
+	fake.getVirtualInstanceMutex.Lock()
+	ret, specificReturn := fake.getVirtualInstanceReturnsOnCall[len(fake.getVirtualInstanceArgsForCall)]
+	fake.getVirtualInstanceArgsForCall = append(fake.getVirtualInstanceArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
+	stub := fake.GetVirtualInstanceStub
+	fakeReturns := fake.getVirtualInstanceReturns
+	fake.recordInvocation("GetVirtualInstance", []interface{}{arg1, arg2})
+	fake.getVirtualInstanceMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeRockClient) GetVirtualInstanceCallCount() int {
+	fake.getVirtualInstanceMutex.RLock()
+	defer fake.getVirtualInstanceMutex.RUnlock()
+	return len(fake.getVirtualInstanceArgsForCall)
+}
+
+func (fake *FakeRockClient) GetVirtualInstanceCalls(stub func(context.Context, string) (openapi.VirtualInstance, error)) {
+	fake.getVirtualInstanceMutex.Lock()
+	defer fake.getVirtualInstanceMutex.Unlock()
+	fake.GetVirtualInstanceStub = stub
+}
+
+func (fake *FakeRockClient) GetVirtualInstanceArgsForCall(i int) (context.Context, string) {
+	fake.getVirtualInstanceMutex.RLock()
+	defer fake.getVirtualInstanceMutex.RUnlock()
+	argsForCall := fake.getVirtualInstanceArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeRockClient) GetVirtualInstanceReturns(result1 openapi.VirtualInstance, result2 error) {
+	fake.getVirtualInstanceMutex.Lock()
+	defer fake.getVirtualInstanceMutex.Unlock()
+	fake.GetVirtualInstanceStub = nil
+	fake.getVirtualInstanceReturns = struct {
+		result1 openapi.VirtualInstance
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeRockClient) GetVirtualInstanceReturnsOnCall(i int, result1 openapi.VirtualInstance, result2 error) {
+	fake.getVirtualInstanceMutex.Lock()
+	defer fake.getVirtualInstanceMutex.Unlock()
+	fake.GetVirtualInstanceStub = nil
+	if fake.getVirtualInstanceReturnsOnCall == nil {
+		fake.getVirtualInstanceReturnsOnCall = make(map[int]struct {
+			result1 openapi.VirtualInstance
+			result2 error
+		})
+	}
+	fake.getVirtualInstanceReturnsOnCall[i] = struct {
+		result1 openapi.VirtualInstance
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeRockClient) ListAPIKeys(arg1 context.Context, arg2 ...option.APIKeyOption) ([]openapi.ApiKey, error) {
+	fake.listAPIKeysMutex.Lock()
+	ret, specificReturn := fake.listAPIKeysReturnsOnCall[len(fake.listAPIKeysArgsForCall)]
+	fake.listAPIKeysArgsForCall = append(fake.listAPIKeysArgsForCall, struct {
+		arg1 context.Context
+		arg2 []option.APIKeyOption
+	}{arg1, arg2})
+	stub := fake.ListAPIKeysStub
+	fakeReturns := fake.listAPIKeysReturns
+	fake.recordInvocation("ListAPIKeys", []interface{}{arg1, arg2})
+	fake.listAPIKeysMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2...)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeRockClient) ListAPIKeysCallCount() int {
+	fake.listAPIKeysMutex.RLock()
+	defer fake.listAPIKeysMutex.RUnlock()
+	return len(fake.listAPIKeysArgsForCall)
+}
+
+func (fake *FakeRockClient) ListAPIKeysCalls(stub func(context.Context, ...option.APIKeyOption) ([]openapi.ApiKey, error)) {
+	fake.listAPIKeysMutex.Lock()
+	defer fake.listAPIKeysMutex.Unlock()
+	fake.ListAPIKeysStub = stub
+}
+
+func (fake *FakeRockClient) ListAPIKeysArgsForCall(i int) (context.Context, []option.APIKeyOption) {
+	fake.listAPIKeysMutex.RLock()
+	defer fake.listAPIKeysMutex.RUnlock()
+	argsForCall := fake.listAPIKeysArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeRockClient) ListAPIKeysReturns(result1 []openapi.ApiKey, result2 error) {
+	fake.listAPIKeysMutex.Lock()
+	defer fake.listAPIKeysMutex.Unlock()
+	fake.ListAPIKeysStub = nil
+	fake.listAPIKeysReturns = struct {
+		result1 []openapi.ApiKey
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeRockClient) ListAPIKeysReturnsOnCall(i int, result1 []openapi.ApiKey, result2 error) {
+	fake.listAPIKeysMutex.Lock()
+	defer fake.listAPIKeysMutex.Unlock()
+	fake.ListAPIKeysStub = nil
+	if fake.listAPIKeysReturnsOnCall == nil {
+		fake.listAPIKeysReturnsOnCall = make(map[int]struct {
+			result1 []openapi.ApiKey
+			result2 error
+		})
+	}
+	fake.listAPIKeysReturnsOnCall[i] = struct {
+		result1 []openapi.ApiKey
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeRockClient) Query(arg1 context.Context, arg2 string, arg3 ...option.QueryOption) (openapi.QueryResponse, error) {
 	fake.queryMutex.Lock()
 	ret, specificReturn := fake.queryReturnsOnCall[len(fake.queryArgsForCall)]
@@ -176,8 +569,18 @@ func (fake *FakeRockClient) QueryReturnsOnCall(i int, result1 openapi.QueryRespo
 func (fake *FakeRockClient) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
+	fake.getCurrentUserMutex.RLock()
+	defer fake.getCurrentUserMutex.RUnlock()
 	fake.getOrganizationMutex.RLock()
 	defer fake.getOrganizationMutex.RUnlock()
+	fake.getQueryInfoMutex.RLock()
+	defer fake.getQueryInfoMutex.RUnlock()
+	fake.getRoleMutex.RLock()
+	defer fake.getRoleMutex.RUnlock()
+	fake.getVirtualInstanceMutex.RLock()
+	defer fake.getVirtualInstanceMutex.RUnlock()
+	fake.listAPIKeysMutex.RLock()
+	defer fake.listAPIKeysMutex.RUnlock()
 	fake.queryMutex.RLock()
 	defer fake.queryMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
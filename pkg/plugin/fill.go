@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// FillNull fills the missing time buckets of a series with null values
+	FillNull = "null"
+	// FillZero fills the missing time buckets of a series with zero, and other types than numbers with null values
+	FillZero = "zero"
+	// FillPrevious fills the missing time buckets of a series with the previous value of the series
+	FillPrevious = "previous"
+
+	// maxFillBuckets is the most time buckets a series is filled up to
+	maxFillBuckets = 100_000
+)
+
+func validFill(fill string) bool {
+	switch fill {
+	case "", FillNull, FillZero, FillPrevious:
+		return true
+	default:
+		return false
+	}
+}
+
+// fillInterval returns the interval of the time buckets, which is the interval of the query model when set,
+// and otherwise the interval of the query
+func fillInterval(qm MetricsQueryModel, query backend.DataQuery) time.Duration {
+	if qm.IntervalMs > 0 {
+		return time.Duration(qm.IntervalMs) * time.Millisecond
+	}
+
+	return query.Interval
+}
+
+// fillGaps adds a row for each time bucket of the time range which the series doesn't have a row for, where
+// the time buckets are aligned to the Unix epoch like TIME_BUCKET. The rows are sorted by time, and rows which
+// aren't at the start of a time bucket are kept as they are. Series without a time column are returned unchanged.
+func fillGaps(fields []*data.Field, fill string, interval time.Duration, tr backend.TimeRange) []*data.Field {
+	timeIndex := timeField(fields)
+	if timeIndex == -1 {
+		return fields
+	}
+	times := fields[timeIndex]
+	order := timeOrder(times)
+	filled := emptyFields(fields)
+
+	appendRow := func(i int) {
+		for j, f := range fields {
+			filled[j].Append(f.At(i))
+		}
+	}
+	appendBucket := func(t time.Time) {
+		for j, f := range filled {
+			if j == timeIndex {
+				f.Append(t)
+				continue
+			}
+			f.Extend(1)
+			last := f.Len() - 1
+			switch {
+			case fill == FillZero && f.Type() == data.FieldTypeNullableFloat64:
+				zero := float64(0)
+				f.Set(last, &zero)
+			case fill == FillPrevious && last > 0:
+				f.Set(last, f.At(last-1))
+			}
+		}
+	}
+
+	next := 0
+	bucket := bucketStart(tr.From, interval)
+	for ; bucket.Before(tr.To); bucket = bucket.Add(interval) {
+		found := false
+		for ; next < len(order); next++ {
+			t := times.At(order[next]).(time.Time)
+			if t.After(bucket) {
+				break
+			}
+			found = found || t.Equal(bucket)
+			appendRow(order[next])
+		}
+		if !found {
+			appendBucket(bucket)
+		}
+	}
+	for ; next < len(order); next++ {
+		appendRow(order[next])
+	}
+
+	return filled
+}
+
+// bucketStart returns the start of the time bucket of t, aligned to the Unix epoch like TIME_BUCKET
+func bucketStart(t time.Time, interval time.Duration) time.Time {
+	return time.UnixMilli(t.UnixMilli() / interval.Milliseconds() * interval.Milliseconds()).UTC()
+}
+
+// fillSeries fills the gaps of each series, unless there would be too many time buckets
+func fillSeries(ss [][]*data.Field, fill string, interval time.Duration, tr backend.TimeRange) ([][]*data.Field, []data.Notice) {
+	// the time buckets are in milliseconds, like the interval parameter of the query
+	if fill == "" || interval < time.Millisecond {
+		return ss, nil
+	}
+	if buckets := tr.To.Sub(tr.From) / interval; buckets > maxFillBuckets {
+		return ss, []data.Notice{{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf("the gaps weren't filled, as the time range has %d intervals of %s, more than %d",
+				buckets, interval, maxFillBuckets),
+		}}
+	}
+
+	for i, fields := range ss {
+		ss[i] = fillGaps(fields, fill, interval, tr)
+	}
+
+	return ss, nil
+}
+
+// timeField returns the index of the time field of the series, or -1 if it has none
+func timeField(fields []*data.Field) int {
+	for i, f := range fields {
+		if f.Type() == data.FieldTypeTime {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// timeOrder returns the indexes of the rows sorted by time
+func timeOrder(times *data.Field) []int {
+	order := make([]int, times.Len())
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return times.At(order[i]).(time.Time).Before(times.At(order[j]).(time.Time))
+	})
+
+	return order
+}
+
+// emptyFields returns fields without rows, with the same names, types, labels and config as the fields
+func emptyFields(fields []*data.Field) []*data.Field {
+	empty := make([]*data.Field, len(fields))
+	for i, f := range fields {
+		empty[i] = data.NewFieldFromFieldType(f.Type(), 0)
+		empty[i].Name, empty[i].Labels, empty[i].Config = f.Name, f.Labels, f.Config
+	}
+
+	return empty
+}
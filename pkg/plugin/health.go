@@ -0,0 +1,225 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// The status of a health check step
+const (
+	HealthStepOK      = "ok"
+	HealthStepWarning = "warning"
+	HealthStepError   = "error"
+	HealthStepSkipped = "skipped"
+)
+
+// HealthStep is the result of one of the steps of the health check, which is reported in the JSONDetails
+type HealthStep struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// HealthDetails is returned as the JSONDetails of the health check
+type HealthDetails struct {
+	Steps []HealthStep `json:"steps"`
+}
+
+// healthCheck keeps track of the steps of a health check
+type healthCheck struct {
+	details HealthDetails
+}
+
+// step runs fn and records its result and latency, and returns true if it succeeded
+func (h *healthCheck) step(name string, fn func() (string, error)) bool {
+	start := time.Now()
+	msg, err := fn()
+	s := HealthStep{
+		Name:      name,
+		Status:    HealthStepOK,
+		Message:   msg,
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		s.Status = HealthStepError
+		s.Message = err.Error()
+	}
+	log.DefaultLogger.Debug("CheckHealth step", "name", s.Name, "status", s.Status, "message", s.Message,
+		"latency", s.LatencyMs)
+	h.details.Steps = append(h.details.Steps, s)
+
+	return err == nil
+}
+
+// skip records a step which wasn't run
+func (h *healthCheck) skip(name, msg string) {
+	h.details.Steps = append(h.details.Steps, HealthStep{Name: name, Status: HealthStepSkipped, Message: msg})
+}
+
+// warn downgrades the last step from error to warning, for steps which aren't required to pass
+func (h *healthCheck) warn() {
+	if n := len(h.details.Steps); n > 0 && h.details.Steps[n-1].Status == HealthStepError {
+		h.details.Steps[n-1].Status = HealthStepWarning
+	}
+}
+
+func (h *healthCheck) result(status backend.HealthStatus, msg string, args ...any) *backend.CheckHealthResult {
+	details, err := json.Marshal(h.details)
+	if err != nil {
+		log.DefaultLogger.Error("failed to marshal health check details", "error", err.Error())
+	}
+
+	return &backend.CheckHealthResult{
+		Status:      status,
+		Message:     fmt.Sprintf(msg, args...),
+		JSONDetails: details,
+	}
+}
+
+// CheckHealth handles health checks sent from Grafana to the plugin.
+// The main use case for these health checks is the test button on the
+// datasource configuration page which allows users to verify that
+// a datasource is working as expected.
+//
+// The health check is done in steps, which are all reported in the JSONDetails:
+//  1. get the organization, which requires the GET_ORG_GLOBAL permission, so it is allowed to fail
+//  2. verify that the virtual instance exists and is active, if one is configured
+//  3. verify that the API key can query, by executing `SELECT 1` on the virtual instance
+//  4. look up the roles of the API key, and report any privileges the plugin needs which the roles don't grant
+func (d *RocksetDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	log.DefaultLogger.Debug("CheckHealth called")
+
+	settings, err := loadSettings(req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		return healthError("invalid datasource settings: %s", err.Error()), nil
+	}
+
+	rs, err := d.newClient(ctx, settings, nil)
+	if err != nil {
+		return healthError("failed to create Rockset client: %s", err.Error()), nil
+	}
+
+	var h healthCheck
+
+	var org string
+	orgFound := h.step("organization", func() (string, error) {
+		o, err := rs.GetOrganization(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to get organization, which requires the GET_ORG_GLOBAL permission: %w", err)
+		}
+		org = o.GetId()
+		return "connected to " + org, nil
+	})
+	if !orgFound {
+		// the API key might lack the permission, so rely on the query step to tell if we can connect
+		h.warn()
+	}
+
+	if settings.VI == "" {
+		h.skip("virtual instance", "no virtual instance configured, queries use the main virtual instance")
+	} else {
+		ok := h.step("virtual instance", func() (string, error) {
+			vi, err := rs.GetVirtualInstance(ctx, settings.VI)
+			if err != nil {
+				return "", fmt.Errorf("failed to get virtual instance %s: %w", settings.VI, err)
+			}
+			if vi.GetState() != option.VirtualInstanceActive.String() {
+				return "", fmt.Errorf("virtual instance %s is %s, not %s", vi.GetName(), vi.GetState(),
+					option.VirtualInstanceActive)
+			}
+			return fmt.Sprintf("virtual instance %s is %s", vi.GetName(), vi.GetState()), nil
+		})
+		if !ok {
+			return h.result(backend.HealthStatusError, "virtual instance check failed: %s", h.lastMessage()), nil
+		}
+	}
+
+	ok := h.step("query", func() (string, error) {
+		var options []option.QueryOption
+		if settings.VI != "" {
+			options = append(options, option.WithVirtualInstance(settings.VI))
+		}
+		if _, err := rs.Query(ctx, "SELECT 1", options...); err != nil {
+			return "", fmt.Errorf("failed to execute query: %w", err)
+		}
+		return "executed SELECT 1", nil
+	})
+	if !ok {
+		log.DefaultLogger.Error("CheckHealth failed", "err", h.lastMessage())
+		return h.result(backend.HealthStatusError, "failed to query Rockset: %s", h.lastMessage()), nil
+	}
+	log.DefaultLogger.Debug("CheckHealth successful", "org", org)
+
+	msg := "Rockset datasource is working, connected to " + org
+	if !orgFound {
+		msg = "Rockset datasource is working, but the API key lacks the GET_ORG_GLOBAL permission"
+	}
+
+	// the permission steps are informational, as the query step already verified that the key works
+	var roles []string
+	rolesFound := h.step("api key", func() (string, error) {
+		var name string
+		var err error
+		name, roles, err = apiKeyRoles(ctx, rs, settings.APIKey)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("API key %s has the roles: %s", name, strings.Join(roles, ", ")), nil
+	})
+	if !rolesFound {
+		h.warn()
+		h.skip("permissions", "the permissions can't be checked without the roles of the API key")
+		return h.result(backend.HealthStatusOk, msg), nil
+	}
+
+	var privileges []string
+	if !h.step("permissions", func() (string, error) {
+		var err error
+		privileges, err = missingPrivileges(ctx, rs, roles)
+		if err != nil {
+			return "", err
+		}
+		if len(privileges) > 0 {
+			return "the API key is not allowed to " + strings.Join(privileges, ", "), nil
+		}
+		return "the API key has all required permissions", nil
+	}) {
+		h.warn()
+	}
+	if len(privileges) > 0 {
+		h.details.Steps[len(h.details.Steps)-1].Status = HealthStepWarning
+		msg += ", but the API key is not allowed to " + strings.Join(privileges, ", ")
+	}
+
+	return h.result(backend.HealthStatusOk, msg), nil
+}
+
+// lastMessage returns the message of the last step
+func (h *healthCheck) lastMessage() string {
+	if len(h.details.Steps) == 0 {
+		return ""
+	}
+
+	return h.details.Steps[len(h.details.Steps)-1].Message
+}
+
+func healthError(msg string, args ...any) *backend.CheckHealthResult {
+	var message string
+	if len(args) > 0 {
+		message = fmt.Sprintf(msg, args...)
+	} else {
+		message = msg
+	}
+	return &backend.CheckHealthResult{
+		Status:  backend.HealthStatusError,
+		Message: message,
+	}
+}
@@ -0,0 +1,379 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// DefaultIncrementalOverlap is how far back before the previous refresh an incremental refresh queries,
+	// so rows which arrived late are picked up, unless set in the query
+	DefaultIncrementalOverlap = time.Minute
+	// maxIncrementalAge is how long the frames of a query are kept without being refreshed
+	maxIncrementalAge = time.Hour
+)
+
+// incrementalStore keeps the frames of incremental queries from the previous refresh. The zero value is empty.
+type incrementalStore struct {
+	mu      sync.Mutex
+	entries map[string]incrementalEntry
+}
+
+// incrementalEntry is the result of an incremental query, covering the time range from and to
+type incrementalEntry struct {
+	frames  data.Frames
+	from    time.Time
+	to      time.Time
+	updated time.Time
+}
+
+func (s *incrementalStore) get(key string) (incrementalEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, found := s.entries[key]
+	return e, found
+}
+
+// set stores the entry, and removes the entries which haven't been refreshed for maxIncrementalAge, as well as
+// the oldest entry when there are too many
+func (s *incrementalStore) set(key string, e incrementalEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.entries == nil {
+		s.entries = make(map[string]incrementalEntry)
+	}
+
+	if _, found := s.entries[key]; !found && len(s.entries) >= maxCacheEntries {
+		var oldest string
+		for k, old := range s.entries {
+			if e.updated.Sub(old.updated) > maxIncrementalAge {
+				delete(s.entries, k)
+				continue
+			}
+			if oldest == "" || old.updated.Before(s.entries[oldest].updated) {
+				oldest = k
+			}
+		}
+		if len(s.entries) >= maxCacheEntries {
+			delete(s.entries, oldest)
+		}
+	}
+
+	s.entries[key] = e
+}
+
+func (s *incrementalStore) remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+// incrementalQuery runs a metrics query which has incremental refresh turned on only for the time since the
+// previous refresh, minus the overlap, and merges the new rows into the frames of the previous refresh,
+// dropping the rows which have fallen out of the time range. The whole time range is queried when there is
+// no previous refresh, or when the time range or the columns have changed since.
+func (d *RocksetDatasource) incrementalQuery(req *backend.QueryDataRequest, settings Settings, query backend.DataQuery,
+	run func(backend.DataQuery) backend.DataResponse) backend.DataResponse {
+	var qm MetricsQueryModel
+	if err := json.Unmarshal(query.JSON, &qm); err != nil || !qm.Incremental || qm.QueryPlan != "" ||
+		req.Headers[fromAlertHeader] == "true" {
+		return run(query)
+	}
+
+	overlap := DefaultIncrementalOverlap
+	if qm.IncrementalOverlap != "" {
+		var err error
+		if overlap, err = time.ParseDuration(qm.IncrementalOverlap); err != nil || overlap < 0 {
+			log.DefaultLogger.Warn("invalid incremental overlap, using default", "refId", query.RefID,
+				"overlap", qm.IncrementalOverlap)
+			overlap = DefaultIncrementalOverlap
+		}
+	}
+
+	key, err := incrementalKey(req.PluginContext.DataSourceInstanceSettings.UID, settings.VI, qm)
+	if err != nil {
+		log.DefaultLogger.Error("failed to create incremental key", "refId", query.RefID, "error", err.Error())
+		return run(query)
+	}
+
+	interval := fillInterval(qm, query)
+	if qm.Downsample == "" || qm.MaxDataPoints <= 0 {
+		return d.incrementalRefresh(key, query, overlap, interval, run)
+	}
+
+	// the series are kept at full resolution, and downsampled after the new rows are merged, as merging new rows
+	// into downsampled series would mix downsampled and raw rows
+	if query.JSON, err = withoutDownsampling(query.JSON); err != nil {
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream,
+			fmt.Sprintf("failed to unmarshal query: %v", err.Error()))
+	}
+	res := d.incrementalRefresh(key, query, overlap, interval, run)
+	if res.Error != nil {
+		return res
+	}
+
+	return downsampleResponse(res, qm.Downsample, int(qm.MaxDataPoints))
+}
+
+// incrementalRefresh queries the time since the previous refresh and merges it into the stored frames, or queries
+// the whole time range when they can't be merged. The time since is rounded down to the time bucket interval,
+// so the first bucket of the new rows is complete, and replaces the bucket of the previous refresh.
+func (d *RocksetDatasource) incrementalRefresh(key string, query backend.DataQuery, overlap, interval time.Duration,
+	run func(backend.DataQuery) backend.DataResponse) backend.DataResponse {
+	from, to := query.TimeRange.From, query.TimeRange.To
+	prev, found := d.incremental.get(key)
+	since := prev.to.Add(-overlap)
+	if interval >= time.Millisecond {
+		since = bucketStart(since, interval)
+	}
+	if !found || time.Since(prev.updated) > maxIncrementalAge ||
+		from.Before(prev.from) || to.Before(prev.to) || !since.After(from) {
+		return d.fullRefresh(key, query, run)
+	}
+
+	delta := query
+	delta.TimeRange.From = since
+	res := run(delta)
+	if res.Error != nil {
+		d.incremental.remove(key)
+		return res
+	}
+
+	frames, ok := mergeFrames(prev.frames, res.Frames, from, since)
+	if !ok {
+		log.DefaultLogger.Debug("columns changed, refreshing the whole time range", "refId", query.RefID)
+		return d.fullRefresh(key, query, run)
+	}
+	log.DefaultLogger.Debug("incremental refresh", "refId", query.RefID, "since", since)
+
+	res.Frames = frames
+	d.incremental.set(key, incrementalEntry{frames: copyResponse(res).Frames, from: from, to: to, updated: time.Now()})
+
+	// the frames share their meta with the stored frames
+	res = copyResponse(res)
+	if len(res.Frames) > 0 && res.Frames[0].Meta != nil {
+		res.Frames[0].Meta.Stats = append(res.Frames[0].Meta.Stats, data.QueryStat{
+			FieldConfig: data.FieldConfig{DisplayName: "incremental refresh since", Unit: "dateTimeAsIso"},
+			Value:       float64(since.UnixMilli()),
+		})
+	}
+
+	return res
+}
+
+// withoutDownsampling returns the query JSON without the downsampling, keeping all other fields as they are
+func withoutDownsampling(b json.RawMessage) (json.RawMessage, error) {
+	var query map[string]interface{}
+	if err := json.Unmarshal(b, &query); err != nil {
+		return nil, err
+	}
+	delete(query, "downsample")
+
+	return json.Marshal(query)
+}
+
+// downsampleResponse returns a copy of the response where each series is downsampled, so the stored frames
+// aren't changed
+func downsampleResponse(res backend.DataResponse, mode string, maxPoints int) backend.DataResponse {
+	res = copyResponse(res)
+	downsampled := 0
+	for _, frame := range res.Frames {
+		if fields, ok := downsample(frame.Fields, mode, maxPoints); ok {
+			frame.Fields = fields
+			downsampled++
+		}
+	}
+	if downsampled > 0 {
+		res.Frames[0].AppendNotices(downsampleNotice(downsampled, len(res.Frames), maxPoints, mode))
+	}
+
+	return res
+}
+
+// fullRefresh runs the query for the whole time range, and keeps the frames for the next refresh
+func (d *RocksetDatasource) fullRefresh(key string, query backend.DataQuery,
+	run func(backend.DataQuery) backend.DataResponse) backend.DataResponse {
+	res := run(query)
+	if res.Error != nil {
+		d.incremental.remove(key)
+		return res
+	}
+
+	d.incremental.set(key, incrementalEntry{
+		frames:  copyResponse(res).Frames,
+		from:    query.TimeRange.From,
+		to:      query.TimeRange.To,
+		updated: time.Now(),
+	})
+
+	return res
+}
+
+// incrementalKey identifies the query regardless of the time range, so refreshes of it can be merged
+func incrementalKey(uid, vi string, qm MetricsQueryModel) (string, error) {
+	b, err := json.Marshal(struct {
+		UID           string   `json:"uid"`
+		VI            string   `json:"vi"`
+		SQL           string   `json:"sql"`
+		ParamStart    string   `json:"paramStart"`
+		ParamStop     string   `json:"paramStop"`
+		IntervalMs    uint64   `json:"intervalMs"`
+		MaxDataPoints int32    `json:"maxDataPoints"`
+		TimeField     string   `json:"timeField"`
+		LabelColumn   string   `json:"labelColumn"`
+		TimeOffsets   []string `json:"timeOffsets"`
+		Fill          string   `json:"fill"`
+		Downsample    string   `json:"downsample"`
+		RowLimit      int32    `json:"rowLimit"`
+	}{uid, vi, qm.QueryText, qm.QueryParamStart, qm.QueryParamStop, qm.IntervalMs, qm.MaxDataPoints,
+		qm.QueryTimeField, qm.QueryLabelColumn, qm.TimeOffsets, qm.Fill, qm.Downsample, qm.RowLimit})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// mergeFrames merges the frames of the new rows with the frames of the previous refresh, by series. The previous
+// rows from before since are kept, the new rows replace everything after since, and rows before from are dropped.
+// The new frames come first, so the frame meta of the new response is on the first frame. It returns false when
+// the columns of a series have changed, or a series has no time column, so the frames can't be merged.
+func mergeFrames(prev, next data.Frames, from, since time.Time) (data.Frames, bool) {
+	// the empty frame of a query without rows has no series
+	empty := next
+	var rows int
+	for _, frame := range next {
+		rows += frame.Rows()
+	}
+	if rows == 0 {
+		next = nil
+	}
+
+	bySeries := make(map[string]*data.Frame)
+	for _, frame := range prev {
+		bySeries[seriesKey(frame)] = frame
+	}
+
+	var merged data.Frames
+	for _, frame := range next {
+		key := seriesKey(frame)
+		old, found := bySeries[key]
+		delete(bySeries, key)
+		if found && !sameColumns(old, frame) {
+			return nil, false
+		}
+
+		out, ok := filterRows(frame, nil, func(t time.Time) bool { return !t.Before(from) })
+		if !ok {
+			return nil, false
+		}
+		if found {
+			if out, ok = filterRows(old, out, func(t time.Time) bool { return !t.Before(from) && t.Before(since) }); !ok {
+				return nil, false
+			}
+		}
+		merged = append(merged, out)
+	}
+
+	// the series without new rows
+	for _, frame := range prev {
+		if _, found := bySeries[seriesKey(frame)]; !found {
+			continue
+		}
+		out, ok := filterRows(frame, nil, func(t time.Time) bool { return !t.Before(from) && t.Before(since) })
+		if !ok {
+			return nil, false
+		}
+		if out.Rows() > 0 {
+			merged = append(merged, out)
+		}
+	}
+
+	if len(merged) == 0 {
+		return empty, true
+	}
+
+	return merged, true
+}
+
+// seriesKey returns the labels of the series of the frame
+func seriesKey(frame *data.Frame) string {
+	for _, f := range frame.Fields {
+		if f.Labels != nil {
+			return f.Labels.String()
+		}
+	}
+
+	return ""
+}
+
+func sameColumns(a, b *data.Frame) bool {
+	if len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Fields {
+		if a.Fields[i].Name != b.Fields[i].Name || a.Fields[i].Type() != b.Fields[i].Type() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filterRows returns the rows of the frame where the time matches keep. When out is nil the rows are returned
+// in a new frame with the same meta, otherwise the rows are inserted in front of the rows of out, which must have
+// the same columns. It returns false if the frame has no time column.
+func filterRows(frame, out *data.Frame, keep func(time.Time) bool) (*data.Frame, bool) {
+	timeIndex := -1
+	for i, f := range frame.Fields {
+		if f.Type() == data.FieldTypeTime {
+			timeIndex = i
+			break
+		}
+	}
+	if timeIndex == -1 {
+		return nil, false
+	}
+
+	filtered := data.NewFrame(frame.Name)
+	filtered.Meta = frame.Meta
+	for _, f := range frame.Fields {
+		field := data.NewFieldFromFieldType(f.Type(), 0)
+		field.Name, field.Labels, field.Config = f.Name, f.Labels, f.Config
+		filtered.Fields = append(filtered.Fields, field)
+	}
+
+	for i := 0; i < frame.Rows(); i++ {
+		if !keep(frame.Fields[timeIndex].At(i).(time.Time)) {
+			continue
+		}
+		for j, f := range frame.Fields {
+			filtered.Fields[j].Append(f.At(i))
+		}
+	}
+	if out == nil {
+		return filtered, true
+	}
+
+	// the rows of out come after the filtered rows
+	for i := 0; i < out.Rows(); i++ {
+		for j, f := range out.Fields {
+			filtered.Fields[j].Append(f.At(i))
+		}
+	}
+	filtered.Meta = out.Meta
+
+	return filtered, true
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// rowLimit returns the row limit of a query, which is the lower of the row limit of the query and max,
+// where 0 is no limit
+func rowLimit(requested, max int32) int32 {
+	if max > 0 && (requested <= 0 || requested > max) {
+		return max
+	}
+
+	return requested
+}
+
+func rowLimitWarning(limit int32) string {
+	return fmt.Sprintf("the result was truncated to the row limit of %d rows", limit)
+}
+
+type maxRowLimitKey struct{}
+
+// withMaxRowLimit returns a context with the row limit of the datasource, for queries which are split into
+// several queries to Rockset, so the limit can be applied to the combined result
+func withMaxRowLimit(ctx context.Context, max int32) context.Context {
+	return context.WithValue(ctx, maxRowLimitKey{}, max)
+}
+
+// maxRowLimitFrom returns the row limit of the datasource of the context, or 0 if it has none
+func maxRowLimitFrom(ctx context.Context) int32 {
+	max, _ := ctx.Value(maxRowLimitKey{}).(int32)
+	return max
+}
+
+// rowLimitClient is a RockClient which enforces the row limit of the datasource, and detects when it, or the
+// row limit of the query, truncated the result
+type rowLimitClient struct {
+	RockClient
+	max int32
+}
+
+// withRowLimit wraps the RockClient so the default row limit of queries is at most max, unless it is 0.
+// It needs to wrap the streaming client, so it can stop the rows which are streamed at the limit.
+func withRowLimit(rs RockClient, max int32) RockClient {
+	return &rowLimitClient{RockClient: rs, max: max}
+}
+
+// Query executes the query with the lower of the row limits of the query and the datasource, but asks for one
+// more row, so if it is returned the result was truncated. The extra row is dropped, and a warning is added
+// to the response.
+func (c *rowLimitClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	limit := rowLimit(applyQueryOptions(sql, options).Sql.GetDefaultRowLimit(), c.max)
+	if limit <= 0 || limit == math.MaxInt32 {
+		return c.RockClient.Query(ctx, sql, options...)
+	}
+
+	options = append(options, option.WithDefaultRowLimit(limit+1))
+	stream := rowStreamFrom(ctx)
+	if stream != nil {
+		stream.maxRows = int(limit)
+	}
+
+	qr, err := c.RockClient.Query(ctx, sql, options...)
+	if err != nil {
+		return qr, err
+	}
+
+	truncated := len(qr.Results) > int(limit)
+	if truncated {
+		qr.Results = qr.Results[:limit]
+	}
+	if stream != nil && stream.limited {
+		truncated = true
+		qr.SetResultsTotalDocCount(int64(stream.rows))
+	}
+	if truncated {
+		qr.Warnings = append(qr.Warnings, rowLimitWarning(limit))
+	}
+
+	return qr, nil
+}
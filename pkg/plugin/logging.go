@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// The levels the SQL and query JSON can be logged at
+const (
+	LogLevelOff   = "off"
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+)
+
+// DefaultLogLevel is the level the SQL and query JSON are logged at unless configured otherwise
+const DefaultLogLevel = LogLevelDebug
+
+// queryLogger logs the SQL and query JSON, which can contain customer data, as configured for the datasource
+type queryLogger struct {
+	level   string
+	redact  bool
+	onError bool
+}
+
+func newQueryLogger(s Settings) queryLogger {
+	level := s.LogLevel
+	if level == "" {
+		level = DefaultLogLevel
+	}
+
+	return queryLogger{level: level, redact: s.RedactLogs, onError: s.LogSQLOnError}
+}
+
+// log logs at the configured level, unless the SQL only should be logged when a query fails
+func (l queryLogger) log(msg string, args ...any) {
+	if l.onError {
+		return
+	}
+
+	switch l.level {
+	case LogLevelInfo:
+		log.DefaultLogger.Info(msg, args...)
+	case LogLevelDebug:
+		log.DefaultLogger.Debug(msg, args...)
+	}
+}
+
+// queryJSON logs the query sent by Grafana
+func (l queryLogger) queryJSON(refID string, raw json.RawMessage) {
+	l.log("query", "refId", refID, "JSON", l.redactJSON(raw))
+}
+
+// query logs the SQL and parameters of a query which is about to be executed
+func (l queryLogger) query(sql string, options []option.QueryOption) {
+	l.log("executing query", l.queryArgs(sql, options)...)
+}
+
+// failed logs the SQL and parameters of a query which failed, if the SQL only should be logged on failure
+func (l queryLogger) failed(sql string, options []option.QueryOption, err error) {
+	if !l.onError {
+		return
+	}
+
+	log.DefaultLogger.Error("query failed", append(l.queryArgs(sql, options), "error", err.Error())...)
+}
+
+func (l queryLogger) queryArgs(sql string, options []option.QueryOption) []any {
+	o := option.QueryOptions{QueryRequest: openapi.NewQueryRequest(*openapi.NewQueryRequestSql(sql))}
+	for _, opt := range options {
+		opt(&o)
+	}
+
+	if l.redact {
+		sql = redactSQL(sql)
+	}
+	args := []any{"SQL", sql}
+	for _, p := range o.Sql.Parameters {
+		value := p.Value
+		if l.redact {
+			value = "?"
+		}
+		args = append(args, ":"+p.Name, value)
+	}
+	if o.Sql.DefaultRowLimit != nil {
+		args = append(args, "row limit", *o.Sql.DefaultRowLimit)
+	}
+	if o.VirtualInstance != nil {
+		args = append(args, "vi", *o.VirtualInstance)
+	}
+
+	return args
+}
+
+// redactJSON redacts the query text of the query JSON
+func (l queryLogger) redactJSON(raw json.RawMessage) string {
+	if !l.redact {
+		return string(raw)
+	}
+
+	var query map[string]any
+	if err := json.Unmarshal(raw, &query); err != nil {
+		return "<redacted>"
+	}
+	if sql, ok := query["queryText"].(string); ok {
+		query["queryText"] = redactSQL(sql)
+	}
+	b, err := json.Marshal(query)
+	if err != nil {
+		return "<redacted>"
+	}
+
+	return string(b)
+}
+
+// loggingClient is a RockClient which logs each query as configured for the datasource
+type loggingClient struct {
+	RockClient
+	logger queryLogger
+}
+
+// withLogging wraps the RockClient so each query is logged using the logging settings of the datasource
+func withLogging(rs RockClient, s Settings) RockClient {
+	return &loggingClient{RockClient: rs, logger: newQueryLogger(s)}
+}
+
+// Query logs the query before it is executed, or only if it fails when the SQL only should be logged on failure
+func (c *loggingClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	c.logger.query(sql, options)
+
+	qr, err := c.RockClient.Query(ctx, sql, options...)
+	if err != nil {
+		c.logger.failed(sql, options, err)
+	}
+
+	return qr, err
+}
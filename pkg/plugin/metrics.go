@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	rockerr "github.com/rockset/rockset-go-client/errors"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// The query types used as the query_type label of the metrics
+const (
+	QueryTypeMetrics     = "metrics"
+	QueryTypeAnnotations = "annotations"
+	QueryTypeVariables   = "variables"
+	QueryTypeStream      = "stream"
+)
+
+const (
+	metricsNamespace = "grafana_plugin"
+	metricsSubsystem = "rockset"
+)
+
+var queryLabels = []string{"query_type", "datasource_uid"}
+
+// The metrics are registered with the default prometheus registry, which the plugin SDK exposes
+// through the metrics endpoint of the plugin
+var (
+	queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "queries_total",
+		Help:      "Number of queries sent to Rockset.",
+	}, queryLabels)
+	queryErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "query_errors_total",
+		Help:      "Number of queries which failed, by the HTTP status code returned by Rockset.",
+	}, []string{"query_type", "datasource_uid", "status_code"})
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "query_duration_seconds",
+		Help:      "Duration of queries as seen by the plugin, including network latency.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, queryLabels)
+	queryElapsed = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "query_elapsed_seconds",
+		Help:      "Elapsed time of queries as reported by Rockset.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, queryLabels)
+	queryThrottled = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "query_throttled_seconds",
+		Help:      "Time queries were throttled as reported by Rockset.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, queryLabels)
+	rowsReturned = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "query_rows",
+		Help:      "Number of rows returned by queries.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	}, queryLabels)
+	paginationTruncations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "pagination_truncations_total",
+		Help:      "Number of queries where the result was truncated as it needed pagination.",
+	}, queryLabels)
+	deduplicatedQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "deduplicated_queries_total",
+		Help:      "Number of queries which shared the response of an identical query already in flight.",
+	}, queryLabels)
+)
+
+// metricsClient is a RockClient which records metrics for each query
+type metricsClient struct {
+	RockClient
+	labels prometheus.Labels
+}
+
+// withMetrics wraps the RockClient so metrics are recorded for each query, labeled by query type and datasource
+func withMetrics(rs RockClient, queryType, datasourceUID string) RockClient {
+	return &metricsClient{
+		RockClient: rs,
+		labels:     prometheus.Labels{"query_type": queryType, "datasource_uid": datasourceUID},
+	}
+}
+
+// Query executes the query and records the metrics
+func (c *metricsClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	start := time.Now()
+	qr, err := c.RockClient.Query(ctx, sql, options...)
+	queryDuration.With(c.labels).Observe(time.Since(start).Seconds())
+	queriesTotal.With(c.labels).Inc()
+
+	if err != nil {
+		statusCode := "unknown"
+		var re rockerr.Error
+		if errors.As(err, &re) && re.StatusCode != 0 {
+			statusCode = strconv.Itoa(re.StatusCode)
+		}
+		queryErrorsTotal.MustCurryWith(c.labels).WithLabelValues(statusCode).Inc()
+		return qr, err
+	}
+
+	rowsReturned.With(c.labels).Observe(float64(resultRows(qr)))
+	if qr.Stats != nil {
+		queryElapsed.With(c.labels).Observe(float64(qr.Stats.GetElapsedTimeMs()) / 1e3)
+		queryThrottled.With(c.labels).Observe(float64(qr.Stats.GetThrottledTimeMicros()) / 1e6)
+	}
+	page := qr.GetPagination()
+	if page.GetNextCursor() != "" {
+		paginationTruncations.With(c.labels).Inc()
+	}
+
+	return qr, nil
+}
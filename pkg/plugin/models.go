@@ -4,7 +4,7 @@ type queryModel interface {
 	GetQueryParamStart() string
 	GetQueryParamStop() string
 	GetIntervalMs() uint64
-	GetMaxDataPoints() int32
+	GetRowLimit() int32
 }
 
 type DatasourceModel struct {
@@ -13,10 +13,10 @@ type DatasourceModel struct {
 }
 
 type BaseQueryModel struct {
-	Datasource   RocksetDatasource `json:"datasource"`
-	RefID        string            `json:"refId"`
-	DatasourceID int32             `json:"datasourceId"`
-	IntervalMs   uint64            `json:"intervalMs"`
+	Datasource   DatasourceModel `json:"datasource"`
+	RefID        string          `json:"refId"`
+	DatasourceID int32           `json:"datasourceId"`
+	IntervalMs   uint64          `json:"intervalMs"`
 }
 
 type QueryModel struct {
@@ -26,16 +26,36 @@ type QueryModel struct {
 	QueryTimeField  string `json:"queryTimeField"`
 	MaxDataPoints   int32  `json:"maxDataPoints"`
 	QueryText       string `json:"queryText"`
+	QueryPlan       string `json:"queryPlan"`
+	// RowLimit is the default row limit of the query, which only applies when the SQL has no LIMIT
+	RowLimit int32 `json:"rowLimit"`
+	// CacheTTL overrides how long the response of the query is cached for, where 0s doesn't cache it
+	CacheTTL string `json:"cacheTTL"`
 }
 
 func (q QueryModel) GetQueryParamStart() string { return q.QueryParamStart }
 func (q QueryModel) GetQueryParamStop() string  { return q.QueryParamStop }
 func (q QueryModel) GetIntervalMs() uint64      { return q.IntervalMs }
 func (q QueryModel) GetMaxDataPoints() int32    { return q.MaxDataPoints }
+func (q QueryModel) GetRowLimit() int32         { return q.RowLimit }
 
 type MetricsQueryModel struct {
 	QueryModel
 	QueryLabelColumn string `json:"queryLabelColumn"`
+	Stream           bool   `json:"stream"`
+	StreamInterval   string `json:"streamInterval"`
+	// Incremental only queries the time since the previous refresh, and merges the rows with the previous result
+	Incremental bool `json:"incremental"`
+	// IncrementalOverlap is how far before the previous refresh is queried again, to pick up rows which arrived late
+	IncrementalOverlap string `json:"incrementalOverlap"`
+	// ChunkInterval splits the time range into chunks of this duration, which are queried in parallel
+	ChunkInterval string `json:"chunkInterval"`
+	// TimeOffsets also queries the time range shifted back by each offset, e.g. 1w, to compare with earlier series
+	TimeOffsets []string `json:"timeOffsets"`
+	// Fill fills the time buckets of each series which have no rows, with null, zero or the previous value
+	Fill string `json:"fill"`
+	// Downsample reduces each series to MaxDataPoints rows, using lttb, avg or minmax
+	Downsample string `json:"downsample"`
 }
 
 type AnnotationsQueryModel struct {
@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// OffsetLabel is the label of the series which are shifted from an earlier time range
+	OffsetLabel = "offset"
+	// maxTimeOffsets is the most time offsets of a query
+	maxTimeOffsets = 10
+)
+
+// timeOffset is an offset of the query, e.g. 1w, and its duration
+type timeOffset struct {
+	name     string
+	duration time.Duration
+}
+
+// parseTimeOffsets parses the time offsets of the query, which use the same units as Grafana, e.g. 1d or 1w.
+// Blank offsets are skipped.
+func parseTimeOffsets(offsets []string) ([]timeOffset, error) {
+	parsed := make([]timeOffset, 0, len(offsets))
+	for _, o := range offsets {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		d, err := gtime.ParseDuration(o)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time offset %q: %w", o, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid time offset %q: it must be positive", o)
+		}
+		parsed = append(parsed, timeOffset{name: o, duration: d})
+	}
+	if len(parsed) > maxTimeOffsets {
+		return nil, fmt.Errorf("a query can have at most %d time offsets", maxTimeOffsets)
+	}
+
+	return parsed, nil
+}
+
+// timeOffsetQuery runs the metrics query for the time range, and for each time offset of the query with the time
+// range shifted back by the offset. The timestamps of the shifted series are moved forward onto the time range,
+// and the series get an offset label, so they can be compared with the current series in the same panel.
+// A shifted query which fails is reported as a notice, and only the current query can fail the response.
+func timeOffsetQuery(query backend.DataQuery, run func(backend.DataQuery) backend.DataResponse) backend.DataResponse {
+	var qm MetricsQueryModel
+	if err := json.Unmarshal(query.JSON, &qm); err != nil || len(qm.TimeOffsets) == 0 {
+		return run(query)
+	}
+
+	offsets, err := parseTimeOffsets(qm.TimeOffsets)
+	if err != nil {
+		return backend.ErrDataResponseWithSource(backend.StatusBadRequest, backend.ErrorSourceDownstream, err.Error())
+	}
+	if len(offsets) == 0 {
+		return run(query)
+	}
+
+	responses := make([]backend.DataResponse, len(offsets))
+	var wg sync.WaitGroup
+	for i, o := range offsets {
+		wg.Add(1)
+		go func(i int, o timeOffset) {
+			defer wg.Done()
+
+			shifted := query
+			shifted.TimeRange = backend.TimeRange{From: query.TimeRange.From.Add(-o.duration),
+				To: query.TimeRange.To.Add(-o.duration)}
+			responses[i] = run(shifted)
+		}(i, o)
+	}
+	res := run(query)
+	wg.Wait()
+
+	if res.Error != nil {
+		return res
+	}
+
+	var notices []data.Notice
+	for i, r := range responses {
+		if r.Error != nil {
+			log.DefaultLogger.Error("time offset query failed", "refId", query.RefID, "offset", offsets[i].name,
+				"error", r.Error.Error())
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("the query with time offset %s failed: %v", offsets[i].name, r.Error),
+			})
+			continue
+		}
+
+		for _, frame := range r.Frames {
+			// the empty frame of a query without rows has no series
+			if frame.Rows() == 0 {
+				continue
+			}
+			shiftFrame(frame, offsets[i])
+			res.Frames = append(res.Frames, frame)
+		}
+	}
+
+	if len(notices) > 0 && len(res.Frames) > 0 {
+		res.Frames[0].AppendNotices(notices...)
+	}
+
+	return res
+}
+
+// shiftFrame moves the timestamps of the frame forward by the offset, and adds the offset label to the values
+func shiftFrame(frame *data.Frame, o timeOffset) {
+	for _, f := range frame.Fields {
+		switch f.Type() {
+		case data.FieldTypeTime:
+			for i := 0; i < f.Len(); i++ {
+				f.Set(i, f.At(i).(time.Time).Add(o.duration))
+			}
+		case data.FieldTypeNullableTime:
+			for i := 0; i < f.Len(); i++ {
+				if t := f.At(i).(*time.Time); t != nil {
+					shifted := t.Add(o.duration)
+					f.Set(i, &shifted)
+				}
+			}
+		default:
+			labels := data.Labels{}
+			for k, v := range f.Labels {
+				labels[k] = v
+			}
+			labels[OffsetLabel] = o.name
+			f.Labels = labels
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// requiredPrivilege is a privilege the plugin needs, and what it is needed for
+type requiredPrivilege struct {
+	// actions which grants the privilege, where the first is the specific action
+	actions     []string
+	description string
+}
+
+// requiredPrivileges are the privileges the API key needs for the plugin to work
+var requiredPrivileges = []requiredPrivilege{
+	{
+		actions:     []string{option.QueryDataWs.String(), option.AllWorkspaceActions.String()},
+		description: "query data in workspaces",
+	},
+	{
+		actions:     []string{option.QueryVirtualInstanceAction.String(), option.AllVirtualInstanceAction.String()},
+		description: "run queries on the virtual instance",
+	},
+	{
+		actions:     []string{option.ExecuteQueryLambdaWs.String(), option.AllWorkspaceActions.String()},
+		description: "execute query lambdas",
+	},
+	{
+		actions:     []string{option.ListWsGlobal.String(), option.AllGlobalActions.String()},
+		description: "list workspaces",
+	},
+	{
+		actions:     []string{option.ListResourcesWs.String(), option.AllWorkspaceActions.String()},
+		description: "list collections and query lambdas in workspaces",
+	},
+	{
+		actions:     []string{option.ListViGlobal.String(), option.AllGlobalActions.String()},
+		description: "list virtual instances",
+	},
+}
+
+// apiKeyRoles looks up the API key among the keys of the current user, and returns the name of the key and
+// the roles it has, which is either the role the key is restricted to, or the roles of the user
+func apiKeyRoles(ctx context.Context, rs RockClient, apiKey string) (string, []string, error) {
+	user, err := rs.GetCurrentUser(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get current user: %w", err)
+	}
+
+	keys, err := rs.ListAPIKeys(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list API keys of %s: %w", user.GetEmail(), err)
+	}
+
+	for _, k := range keys {
+		if !matchesAPIKey(k.GetKey(), apiKey) {
+			continue
+		}
+		if k.GetRole() != "" {
+			return k.GetName(), []string{k.GetRole()}, nil
+		}
+		return k.GetName(), user.GetRoles(), nil
+	}
+
+	return "", nil, fmt.Errorf("could not find the API key among the keys of %s", user.GetEmail())
+}
+
+// matchesAPIKey compares the key returned when listing API keys, where all but a few characters are masked
+// using '*', with the API key
+func matchesAPIKey(masked, apiKey string) bool {
+	if masked == apiKey {
+		return true
+	}
+
+	first := strings.Index(masked, "*")
+	last := strings.LastIndex(masked, "*")
+	if first == -1 || len(masked) != len(apiKey) {
+		return false
+	}
+	prefix, suffix := masked[:first], masked[last+1:]
+	if prefix == "" && suffix == "" {
+		return false
+	}
+
+	return strings.HasPrefix(apiKey, prefix) && strings.HasSuffix(apiKey, suffix)
+}
+
+// missingPrivileges looks up the privileges of the roles and returns a plain language description of each
+// required privilege which isn't granted by any of them
+func missingPrivileges(ctx context.Context, rs RockClient, roles []string) ([]string, error) {
+	granted := make(map[string]struct{})
+	for _, name := range roles {
+		role, err := rs.GetRole(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get role %s: %w", name, err)
+		}
+		for _, p := range role.GetPrivileges() {
+			granted[p.GetAction()] = struct{}{}
+		}
+	}
+
+	return missing(granted, requiredPrivileges), nil
+}
+
+func missing(granted map[string]struct{}, required []requiredPrivilege) []string {
+	var result []string
+
+	for _, r := range required {
+		found := false
+		for _, a := range r.actions {
+			if _, found = granted[a]; found {
+				break
+			}
+		}
+		if !found {
+			result = append(result, fmt.Sprintf("%s (%s)", r.description, r.actions[0]))
+		}
+	}
+
+	return result
+}
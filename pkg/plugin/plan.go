@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// The query plan modes which can be requested using the queryPlan field of the query model
+const (
+	// QueryPlanExplain runs EXPLAIN on the query and attaches the plan to the frame meta
+	QueryPlanExplain = "explain"
+	// QueryPlanGraph is like QueryPlanExplain, but also returns the plan as node graph frames
+	QueryPlanGraph = "graph"
+	// QueryPlanProfile fetches the query info of the executed query and attaches it to the frame meta
+	QueryPlanProfile = "profile"
+)
+
+// QueryPlanMeta is added as custom frame meta, so it shows up in the query inspector
+type QueryPlanMeta struct {
+	QueryID string             `json:"queryId"`
+	Plan    []string           `json:"plan,omitempty"`
+	Profile *openapi.QueryInfo `json:"profile,omitempty"`
+}
+
+// queryPlan executes the extra calls needed for the requested query plan mode, and returns the plan meta
+// together with any node graph frames
+func queryPlan(ctx context.Context, rs RockClient, mode, sql string, options []option.QueryOption,
+	qr openapi.QueryResponse) (QueryPlanMeta, []*data.Frame, []data.Notice) {
+	meta := QueryPlanMeta{QueryID: qr.GetQueryId()}
+	var frames []*data.Frame
+	var notices []data.Notice
+
+	switch mode {
+	case QueryPlanExplain, QueryPlanGraph:
+		plan, err := explainQuery(ctx, rs, sql, options)
+		if err != nil {
+			log.DefaultLogger.Error("failed to explain query", "queryID", meta.QueryID, "error", err.Error())
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     "failed to get query plan: " + err.Error(),
+			})
+			break
+		}
+		// the plan has the literals of the query, so it isn't logged, as it would bypass the query logging settings
+		log.DefaultLogger.Debug("query plan", "queryID", meta.QueryID, "lines", len(plan))
+		meta.Plan = plan
+		if mode == QueryPlanGraph {
+			frames = planGraphFrames(plan)
+		}
+	case QueryPlanProfile:
+		if meta.QueryID == "" {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     "query profile not available as the query has no query ID",
+			})
+			break
+		}
+		info, err := rs.GetQueryInfo(ctx, meta.QueryID)
+		if err != nil {
+			log.DefaultLogger.Error("failed to get query profile", "queryID", meta.QueryID, "error", err.Error())
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     "failed to get query profile: " + err.Error(),
+			})
+			break
+		}
+		meta.Profile = &info
+	default:
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "unknown query plan mode: " + mode,
+		})
+	}
+
+	return meta, frames, notices
+}
+
+// explainQuery runs EXPLAIN with the same options as the query, and returns the plan one line per operator
+func explainQuery(ctx context.Context, rs RockClient, sql string, options []option.QueryOption) ([]string, error) {
+	qr, err := rs.Query(ctx, "EXPLAIN "+sql, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	var column string
+	if len(qr.ColumnFields) > 0 {
+		column = qr.ColumnFields[0].Name
+	}
+
+	var plan []string
+	for _, row := range qr.Results {
+		value, found := row[column]
+		if !found {
+			// fall back to the first value in the row
+			for _, v := range row {
+				value = v
+				break
+			}
+		}
+		if s, ok := value.(string); ok {
+			for _, line := range strings.Split(s, "\n") {
+				if strings.TrimSpace(line) != "" {
+					plan = append(plan, line)
+				}
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// planGraphFrames converts the indented EXPLAIN output into the nodes and edges frames used by the node graph,
+// where each operator has an edge to the operator it feeds its output into
+// https://grafana.com/docs/grafana/latest/panels-visualizations/visualizations/node-graph/#data-api
+func planGraphFrames(plan []string) []*data.Frame {
+	type operator struct {
+		indent int
+		id     string
+	}
+	var ids, titles, subtitles []string
+	var edgeIDs, sources, targets []string
+	var stack []operator
+
+	for i, line := range plan {
+		trimmed := strings.TrimLeft(line, " \t")
+		indent := len(line) - len(trimmed)
+		id := strconv.Itoa(i)
+
+		title, subtitle, _ := strings.Cut(trimmed, " ")
+		ids = append(ids, id)
+		titles = append(titles, title)
+		subtitles = append(subtitles, subtitle)
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1].id
+			edgeIDs = append(edgeIDs, id+"-"+parent)
+			sources = append(sources, id)
+			targets = append(targets, parent)
+		}
+		stack = append(stack, operator{indent: indent, id: id})
+	}
+
+	nodes := data.NewFrame("nodes",
+		data.NewField("id", nil, ids),
+		data.NewField("title", nil, titles),
+		data.NewField("subtitle", nil, subtitles),
+	).SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph})
+	edges := data.NewFrame("edges",
+		data.NewField("id", nil, edgeIDs),
+		data.NewField("source", nil, sources),
+		data.NewField("target", nil, targets),
+	).SetMeta(&data.FrameMeta{PreferredVisualization: data.VisTypeNodeGraph})
+
+	return []*data.Frame{nodes, edges}
+}
@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// dataQuality counts the values which couldn't be used, per column, so the problems can be reported as frame
+// notices instead of just showing up as gaps in the graph
+type dataQuality struct {
+	columns map[string]*columnQuality
+	// the order the columns were first seen in, so the notices are in a stable order
+	order []string
+	// the types of columns which are dropped as they aren't supported
+	unsupported map[string]string
+}
+
+// columnQuality counts the values of a column which are missing or of the wrong type
+type columnQuality struct {
+	label   bool
+	rows    int
+	missing int
+	// the number of dropped values by type
+	mismatched map[string]int
+}
+
+func newDataQuality() *dataQuality {
+	return &dataQuality{
+		columns:     make(map[string]*columnQuality),
+		unsupported: make(map[string]string),
+	}
+}
+
+// column returns the counters of the column
+func (q *dataQuality) column(name string) *columnQuality {
+	c, found := q.columns[name]
+	if !found {
+		c = &columnQuality{mismatched: make(map[string]int)}
+		q.columns[name] = c
+		q.order = append(q.order, name)
+	}
+
+	return c
+}
+
+// labelColumn returns the counters of the label column
+func (q *dataQuality) labelColumn(name string) *columnQuality {
+	c := q.column(name)
+	c.label = true
+
+	return c
+}
+
+// unsupportedColumn records a column which is dropped as the type of its values, as returned by valueType,
+// isn't supported
+func (q *dataQuality) unsupportedColumn(name, kind string) {
+	if _, found := q.unsupported[name]; !found {
+		q.order = append(q.order, name)
+	}
+	q.unsupported[name] = kind
+}
+
+func (c *columnQuality) row() {
+	c.rows++
+}
+
+func (c *columnQuality) missingValue() {
+	c.missing++
+}
+
+func (c *columnQuality) mismatchedValue(value interface{}) {
+	c.mismatched[valueType(value)]++
+}
+
+// notices returns a notice for each problem found
+func (q *dataQuality) notices() []data.Notice {
+	var notices []data.Notice
+
+	for _, name := range q.order {
+		if t, found := q.unsupported[name]; found {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("column %s: values are %s, which aren't supported, so the column was dropped", name, pluralType(t)),
+			})
+		}
+
+		c, found := q.columns[name]
+		if !found {
+			continue
+		}
+
+		prefix, dropped := "column", "were dropped"
+		if c.label {
+			prefix, dropped = "label column", "the rows were dropped"
+		}
+
+		types := make([]string, 0, len(c.mismatched))
+		for t := range c.mismatched {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text: fmt.Sprintf("%s %s: %d of %d values were %s and %s",
+					prefix, name, c.mismatched[t], c.rows, pluralType(t), dropped),
+			})
+		}
+		if c.missing > 0 && c.label {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("%s %s: missing in %d of %d rows and %s", prefix, name, c.missing, c.rows, dropped),
+			})
+		} else if c.missing > 0 {
+			notices = append(notices, data.Notice{
+				Severity: data.NoticeSeverityInfo,
+				Text:     fmt.Sprintf("%s %s: missing in %d of %d rows", prefix, name, c.missing, c.rows),
+			})
+		}
+	}
+
+	return notices
+}
+
+func pluralType(t string) string {
+	switch t {
+	case "bool":
+		return "booleans"
+	case "float":
+		return "numbers"
+	case "null":
+		return "null"
+	default:
+		return t + "s"
+	}
+}
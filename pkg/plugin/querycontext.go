@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// QueryContext identifies where in Grafana a query comes from, so the cost of queries can be attributed
+// using the Rockset query log
+type QueryContext struct {
+	DashboardUID string `json:"dashboardUid,omitempty"`
+	PanelID      string `json:"panelId,omitempty"`
+	User         string `json:"user,omitempty"`
+}
+
+// newQueryContext extracts the dashboard and panel from the request headers, and the user from the plugin context
+func newQueryContext(req *backend.QueryDataRequest, includeUser bool) QueryContext {
+	qc := QueryContext{
+		DashboardUID: req.GetHTTPHeader("X-Dashboard-Uid"),
+		PanelID:      req.GetHTTPHeader("X-Panel-Id"),
+	}
+	if includeUser && req.PluginContext.User != nil {
+		qc.User = req.PluginContext.User.Login
+	}
+
+	return qc
+}
+
+// headers returns the custom headers to send with each request to Rockset
+func (qc QueryContext) headers() map[string]string {
+	headers := make(map[string]string)
+	if qc.DashboardUID != "" {
+		headers["x-grafana-dashboard-uid"] = headerValue(qc.DashboardUID)
+	}
+	if qc.PanelID != "" {
+		headers["x-grafana-panel-id"] = headerValue(qc.PanelID)
+	}
+	if qc.User != "" {
+		headers["x-grafana-user"] = headerValue(qc.User)
+	}
+
+	return headers
+}
+
+// comment returns a SQL comment with the query context, which shows up with the query text in the query log
+func (qc QueryContext) comment() string {
+	if qc == (QueryContext{}) {
+		return ""
+	}
+
+	b, err := json.Marshal(qc)
+	if err != nil {
+		log.DefaultLogger.Error("failed to marshal query context", "error", err.Error())
+		return ""
+	}
+
+	// make sure the values can't terminate the comment, "\/" is a valid JSON escape of "/"
+	return "/* grafana " + strings.ReplaceAll(string(b), "*/", `*\/`) + " */"
+}
+
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// queryContextClient is a RockClient which adds the query context as a comment to each query
+type queryContextClient struct {
+	RockClient
+	comment string
+}
+
+// withQueryContext wraps the RockClient so the query context is appended to each query
+func withQueryContext(rs RockClient, qc QueryContext) RockClient {
+	comment := qc.comment()
+	if comment == "" {
+		return rs
+	}
+
+	return &queryContextClient{RockClient: rs, comment: comment}
+}
+
+// Query appends the query context on a separate line, so the line numbers of errors are unchanged
+func (c *queryContextClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	return c.RockClient.Query(ctx, sql+"\n"+c.comment, options...)
+}
@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+)
+
+// writeError is returned for queries which would write, when the datasource only allows reads
+type writeError struct {
+	keyword string
+}
+
+func (e *writeError) Error() string {
+	return fmt.Sprintf("%s statements aren't allowed, as the datasource only allows queries which read", e.keyword)
+}
+
+// readOnlyClient is a RockClient which rejects queries with statements that write, like INSERT INTO or DELETE,
+// so dashboard editors can't change data with the API key of the datasource
+type readOnlyClient struct {
+	RockClient
+}
+
+// withReadOnly wraps the RockClient so only queries which read are sent to Rockset
+func withReadOnly(rs RockClient) RockClient {
+	return &readOnlyClient{RockClient: rs}
+}
+
+// Query executes the query, unless it has a statement which isn't a read
+func (c *readOnlyClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	if keyword, found := writeStatement(sql); found {
+		return openapi.QueryResponse{}, &writeError{keyword: keyword}
+	}
+
+	return c.RockClient.Query(ctx, sql, options...)
+}
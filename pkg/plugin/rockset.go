@@ -14,6 +14,11 @@ import (
 type RockClient interface {
 	GetOrganization(context.Context) (openapi.Organization, error)
 	Query(context.Context, string, ...option.QueryOption) (openapi.QueryResponse, error)
+	GetQueryInfo(context.Context, string) (openapi.QueryInfo, error)
+	GetVirtualInstance(context.Context, string) (openapi.VirtualInstance, error)
+	GetCurrentUser(context.Context) (openapi.User, error)
+	ListAPIKeys(context.Context, ...option.APIKeyOption) ([]openapi.ApiKey, error)
+	GetRole(context.Context, string) (openapi.Role, error)
 }
 
 func RockFactory(options ...rockset.RockOption) (RockClient, error) {
@@ -0,0 +1,340 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/rockset/rockset-go-client/openapi"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// series is a time series built from the rows with the same value in the label column
+type series struct {
+	label string
+	rows  int
+	times []time.Time
+	// the values of each column, by the index of the column in the seriesBuilder, which are nil until the type of
+	// the column is known
+	values []columnValues
+}
+
+// appendValue appends the value of the column at index i in the row
+func (s *series) appendValue(i int, c *seriesColumn, row map[string]interface{}) bool {
+	if i == len(s.values) {
+		s.values = append(s.values, nil)
+	}
+
+	value, found := row[c.name]
+	if found {
+		c.present++
+		if c.kind == "" && value != nil {
+			c.kind = valueType(value)
+		}
+	}
+
+	if s.values[i] == nil {
+		if !supportedKind(c.kind) {
+			return found
+		}
+		// the previous rows of the series didn't have a value
+		s.values[i] = c.newValues(s.rows)
+	}
+	s.values[i].append(c, value)
+
+	return found
+}
+
+// seriesColumn is a column found in the rows, where the type of the column is the type of its first value
+// which isn't null, as it is assumed every value is of the same type
+type seriesColumn struct {
+	name string
+	// kind is empty until the first value which isn't null, and then the type as returned by valueType
+	kind string
+	// the number of rows which has the column
+	present int
+	// the number of dropped values by type
+	mismatched map[string]int
+}
+
+// newValues returns the values of the column for a series, starting with the nulls of the previous rows
+func (c *seriesColumn) newValues(nulls int) columnValues {
+	switch c.kind {
+	case "bool":
+		return &typedValues[bool]{values: make([]*bool, nulls)}
+	case "string":
+		return &typedValues[string]{values: make([]*string, nulls)}
+	default:
+		return &typedValues[float64]{values: make([]*float64, nulls)}
+	}
+}
+
+func supportedKind(kind string) bool {
+	return kind == "bool" || kind == "string" || kind == "float"
+}
+
+// columnValues are the values of a column of a series
+type columnValues interface {
+	append(c *seriesColumn, value interface{})
+	field(name string, labels data.Labels) *data.Field
+}
+
+// typedValues are the values of a column of type T, where values of other types are counted and dropped
+type typedValues[T any] struct {
+	values []*T
+}
+
+func (v *typedValues[T]) append(c *seriesColumn, value interface{}) {
+	switch x := value.(type) {
+	case T:
+		v.values = append(v.values, &x)
+	case nil:
+		v.values = append(v.values, nil)
+	default:
+		c.mismatched[valueType(value)]++
+		v.values = append(v.values, nil)
+	}
+}
+
+func (v *typedValues[T]) field(name string, labels data.Labels) *data.Field {
+	return data.NewField(name, labels, v.values)
+}
+
+// seriesBuilder partitions the rows by the value of the label column, one row at a time, appending each row
+// to the typed values of its series. The work is proportional to the number of rows times the number of columns,
+// regardless of the number of series, and as the columns are found in the rows, the rows can be appended as they
+// are decoded from the query response. Without a label column all rows are in one series with an empty label.
+type seriesBuilder struct {
+	timeColumn   string
+	labelColumn  string
+	quality      *dataQuality
+	labelQuality *columnQuality
+
+	// rows is the number of rows which have been appended to a series
+	rows int
+	// hasTime is set when the first row has the time column, and then every row must have it
+	hasTime  bool
+	columns  []*seriesColumn
+	byName   map[string]int
+	series   []*series
+	bySeries map[string]*series
+}
+
+func newSeriesBuilder(timeColumn, labelColumn string, quality *dataQuality) *seriesBuilder {
+	// the annotation query doesn't set the time column, unless changed,
+	if timeColumn == "" {
+		timeColumn = DefaultTimeColumn
+	}
+
+	b := &seriesBuilder{
+		timeColumn:  timeColumn,
+		labelColumn: labelColumn,
+		quality:     quality,
+		byName:      make(map[string]int),
+		bySeries:    make(map[string]*series),
+	}
+	if labelColumn != "" {
+		b.labelQuality = quality.labelColumn(labelColumn)
+	}
+
+	return b
+}
+
+// appendRow appends the row to its series. The row isn't retained, so it can be reused for the next row.
+func (b *seriesBuilder) appendRow(row map[string]interface{}) error {
+	label := ""
+	if b.labelColumn != "" {
+		b.labelQuality.row()
+		value, found := row[b.labelColumn]
+		if !found {
+			b.labelQuality.missingValue()
+			return nil
+		}
+		l, ok := value.(string)
+		if !ok {
+			b.labelQuality.mismatchedValue(value)
+			return nil
+		}
+		label = l
+	}
+
+	if b.rows == 0 {
+		_, b.hasTime = row[b.timeColumn]
+	}
+
+	s, found := b.bySeries[label]
+	if !found {
+		s = &series{label: label}
+		b.bySeries[label] = s
+		b.series = append(b.series, s)
+	}
+
+	known := 0
+	if b.hasTime {
+		t, err := b.time(row)
+		if err != nil {
+			return err
+		}
+		s.times = append(s.times, t)
+		known++
+	}
+	if b.labelColumn != "" {
+		known++
+	}
+
+	for i, c := range b.columns {
+		if s.appendValue(i, c, row) {
+			known++
+		}
+	}
+
+	// look for columns which haven't been seen before, as long as the row has more columns than were found
+	if known < len(row) {
+		for name := range row {
+			if _, found := b.byName[name]; found || name == b.labelColumn || name == b.timeColumn {
+				continue
+			}
+
+			c := &seriesColumn{name: name, mismatched: make(map[string]int)}
+			b.byName[name] = len(b.columns)
+			b.columns = append(b.columns, c)
+			s.appendValue(len(b.columns)-1, c, row)
+		}
+	}
+
+	s.rows++
+	b.rows++
+
+	return nil
+}
+
+func (b *seriesBuilder) time(row map[string]interface{}) (time.Time, error) {
+	value, found := row[b.timeColumn]
+	if !found {
+		return time.Time{}, fmt.Errorf("time column not found: %s", b.timeColumn)
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("column %s is of type '%T', not the expected type 'string'", b.timeColumn, value)
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to convert %s to time: %w", s, err)
+	}
+
+	return t, nil
+}
+
+// fields returns the fields of each series in wide format, in the order the label of the series first appeared,
+// with the columns in the order of the column fields reported by Rockset, or when there aren't any, such as for
+// SELECT *, in the order of orderColumns.
+// https://grafana.com/developers/plugin-tools/introduction/data-frames#wide-format
+func (b *seriesBuilder) fields(columnFields []openapi.QueryFieldType) [][]*data.Field {
+	if b.labelColumn == "" && len(b.series) == 0 {
+		b.series = append(b.series, &series{})
+	}
+
+	var names []string
+	hasTime := b.hasTime
+	if len(columnFields) > 0 {
+		for _, c := range columnFields {
+			names = append(names, c.Name)
+			if b.rows == 0 && c.Name == b.timeColumn {
+				hasTime = true
+			}
+		}
+	} else {
+		for _, c := range b.columns {
+			names = append(names, c.name)
+		}
+		if hasTime {
+			names = append(names, b.timeColumn)
+		}
+		names = orderColumns(names)
+	}
+
+	// the index of each column to return, where -1 is the time column
+	var columns []int
+	for _, name := range names {
+		if name == b.labelColumn {
+			continue
+		}
+		if name == b.timeColumn {
+			if hasTime {
+				columns = append(columns, -1)
+			}
+			continue
+		}
+
+		i, found := b.byName[name]
+		if !found || b.columns[i].kind == "" {
+			log.DefaultLogger.Debug("skipping column without values", "name", name)
+			continue
+		}
+		c := b.columns[i]
+		if !supportedKind(c.kind) {
+			log.DefaultLogger.Error("unknown type", "column", name, "type", c.kind)
+			b.quality.unsupportedColumn(name, c.kind)
+			continue
+		}
+
+		cq := b.quality.column(name)
+		cq.rows += b.rows
+		cq.missing += b.rows - c.present
+		for t, n := range c.mismatched {
+			cq.mismatched[t] += n
+		}
+		columns = append(columns, i)
+	}
+
+	result := make([][]*data.Field, len(b.series))
+	for j, s := range b.series {
+		fields := make([]*data.Field, 0, len(columns))
+		for _, i := range columns {
+			if i == -1 {
+				fields = append(fields, data.NewField("time", nil, s.times))
+				continue
+			}
+
+			var values columnValues
+			if i < len(s.values) {
+				values = s.values[i]
+			}
+			if values == nil {
+				// the series has no values for the column
+				values = b.columns[i].newValues(s.rows)
+			}
+			fields = append(fields, values.field(b.columns[i].name, data.Labels{b.labelColumn: s.label}))
+		}
+		result[j] = fields
+	}
+	log.DefaultLogger.Debug("extracted series", "series", len(result), "rows", b.rows)
+
+	return result
+}
+
+// extractSeries partitions the rows of the query response by the value of the label column, and returns the
+// fields of each series
+func extractSeries(ctx context.Context, timeColumn, labelColumn string, qr openapi.QueryResponse,
+	quality *dataQuality) ([][]*data.Field, error) {
+	b := newSeriesBuilder(timeColumn, labelColumn, quality)
+	return b.extract(ctx, &rowStream{appendRow: b.appendRow}, qr)
+}
+
+// extract appends the rows of the query response which weren't already streamed, and returns the fields of
+// each series
+func (b *seriesBuilder) extract(ctx context.Context, rows *rowStream, qr openapi.QueryResponse) ([][]*data.Field, error) {
+	_, span := tracing.DefaultTracer().Start(ctx, "extractSeries",
+		trace.WithAttributes(attributeRows.Int(resultRows(qr)), attributeStreamed.Bool(rows.streamed)))
+	defer span.End()
+
+	if err := rows.fill(qr); err != nil {
+		return nil, err
+	}
+
+	return b.fields(qr.ColumnFields), nil
+}
@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Settings are the datasource instance settings, which are configured in the ConfigEditor
+type Settings struct {
+	APIKey string `json:"-"`
+	Server string `json:"server"`
+	VI     string `json:"vi"`
+	// DisableUserIdentity stops the Grafana user from being sent with the dashboard and panel of each query
+	DisableUserIdentity bool `json:"disableUserIdentity"`
+	// LogLevel is the level the SQL and query JSON are logged at, one of off, debug or info
+	LogLevel string `json:"logLevel"`
+	// RedactLogs removes string literals and parameter values from the SQL and query JSON before they are logged
+	RedactLogs bool `json:"redactLogs"`
+	// LogSQLOnError only logs the SQL when a query fails, and then at error level
+	LogSQLOnError bool `json:"logSqlOnError"`
+	// StreamResults decodes the rows of metric queries as the response is read, instead of all at once
+	StreamResults bool `json:"streamResults"`
+	// MaxResponseBytes stops reading streamed responses after this many bytes, where 0 is no limit
+	MaxResponseBytes int64 `json:"maxResponseBytes"`
+	// AllowWrites lets queries write, e.g. with INSERT INTO or DELETE, which otherwise are rejected
+	AllowWrites bool `json:"allowWrites"`
+	// AllowedCollections are the patterns of the collections queries can reference, like workspace.collection,
+	// where empty allows all collections
+	AllowedCollections []string `json:"allowedCollections"`
+	// DeniedCollections are the patterns of the collections queries can't reference, even if they are allowed
+	DeniedCollections []string `json:"deniedCollections"`
+	// MaxRowLimit is the most rows a query returns, which queries can lower but not raise, where 0 is no limit
+	MaxRowLimit int32 `json:"maxRowLimit"`
+	// CacheTTL is how long the responses of queries are cached for, unless set in the query, where empty is no caching
+	CacheTTL string `json:"cacheTTL"`
+	// CacheBucket is what the time range of queries is aligned to, so queries with a slightly later time range
+	// use the cached response
+	CacheBucket string `json:"cacheBucket"`
+}
+
+// loadSettings extracts the Settings from the datasource instance settings, and is used by every handler
+// which connects to Rockset, so they all apply the same settings
+func loadSettings(s *backend.DataSourceInstanceSettings) (Settings, error) {
+	var settings Settings
+
+	if s == nil {
+		return settings, fmt.Errorf("missing datasource instance settings")
+	}
+
+	if err := json.Unmarshal(s.JSONData, &settings); err != nil {
+		return settings, fmt.Errorf("failed to unmarshal settings json: %w", err)
+	}
+
+	var found bool
+	settings.APIKey, found = s.DecryptedSecureJSONData["apiKey"]
+	if !found {
+		return settings, fmt.Errorf("could not locate apiKey")
+	}
+
+	switch settings.LogLevel {
+	case "", LogLevelOff, LogLevelDebug, LogLevelInfo:
+	default:
+		return settings, fmt.Errorf("invalid log level %s", settings.LogLevel)
+	}
+
+	if settings.MaxResponseBytes < 0 {
+		return settings, fmt.Errorf("invalid max response bytes %d", settings.MaxResponseBytes)
+	}
+
+	if settings.MaxRowLimit < 0 {
+		return settings, fmt.Errorf("invalid max row limit %d", settings.MaxRowLimit)
+	}
+
+	settings.AllowedCollections = nonBlank(settings.AllowedCollections)
+	settings.DeniedCollections = nonBlank(settings.DeniedCollections)
+	for _, p := range append(append([]string(nil), settings.AllowedCollections...), settings.DeniedCollections...) {
+		if err := validCollectionPattern(p); err != nil {
+			return settings, err
+		}
+	}
+
+	for name, d := range map[string]string{"cache TTL": settings.CacheTTL, "cache bucket": settings.CacheBucket} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return settings, fmt.Errorf("invalid %s %s: %w", name, d, err)
+		}
+	}
+
+	return settings, nil
+}
+
+// cacheTTL returns how long responses are cached for, where 0 is no caching
+func (s Settings) cacheTTL() time.Duration {
+	ttl, _ := time.ParseDuration(s.CacheTTL)
+	return ttl
+}
+
+// cacheBucket returns what the time range of queries is aligned to in the cache key
+func (s Settings) cacheBucket() time.Duration {
+	bucket, _ := time.ParseDuration(s.CacheBucket)
+	if bucket <= 0 {
+		return DefaultCacheBucket
+	}
+
+	return bucket
+}
+
+// collectionRules returns the rules of which collections queries can reference
+func (s Settings) collectionRules() collectionRules {
+	return collectionRules{allowed: s.AllowedCollections, denied: s.DeniedCollections}
+}
+
+// nonBlank returns the strings which aren't blank, trimmed
+func nonBlank(ss []string) []string {
+	var out []string
+	for _, s := range ss {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
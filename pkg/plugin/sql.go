@@ -0,0 +1,233 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The kinds of SQL tokens
+const (
+	tokenWhitespace = iota
+	tokenComment
+	tokenString
+	tokenIdentifier
+	tokenWord
+	tokenNumber
+	tokenParameter
+	tokenPunctuation
+)
+
+// sqlToken is a token of a SQL query, where text is the token as it appears in the query
+type sqlToken struct {
+	kind int
+	text string
+}
+
+// tokenizeSQL splits the query into tokens, which is just enough of a lexer to tell string literals, quoted
+// identifiers and comments apart from the rest of the query. Concatenating the text of the tokens returns the query.
+func tokenizeSQL(sql string) []sqlToken {
+	var tokens []sqlToken
+
+	for i := 0; i < len(sql); {
+		kind, end := tokenPunctuation, i+1
+		c := sql[i]
+		switch {
+		case isSpace(c):
+			kind, end = tokenWhitespace, scanWhile(sql, i, isSpace)
+		case strings.HasPrefix(sql[i:], "--"):
+			kind, end = tokenComment, len(sql)
+			if n := strings.IndexByte(sql[i:], '\n'); n != -1 {
+				end = i + n
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			kind, end = tokenComment, len(sql)
+			if n := strings.Index(sql[i+2:], "*/"); n != -1 {
+				end = i + 2 + n + 2
+			}
+		case c == '\'':
+			kind, end = tokenString, scanQuoted(sql, i, '\'')
+		case c == '"' || c == '`':
+			kind, end = tokenIdentifier, scanQuoted(sql, i, c)
+		case c == ':' && i+1 < len(sql) && isWordStart(sql[i+1]):
+			kind, end = tokenParameter, scanWhile(sql, i+1, isWord)
+		case isDigit(c):
+			kind, end = tokenNumber, scanWhile(sql, i, isNumber)
+		case isWordStart(c):
+			kind, end = tokenWord, scanWhile(sql, i, isWord)
+		}
+
+		tokens = append(tokens, sqlToken{kind: kind, text: sql[i:end]})
+		i = end
+	}
+
+	return tokens
+}
+
+// scanQuoted returns the end of the quoted token starting at i, where a doubled quote is an escaped quote
+func scanQuoted(sql string, i int, quote byte) int {
+	for j := i + 1; j < len(sql); j++ {
+		if sql[j] != quote {
+			continue
+		}
+		if j+1 < len(sql) && sql[j+1] == quote {
+			j++
+			continue
+		}
+		return j + 1
+	}
+
+	return len(sql)
+}
+
+func scanWhile(sql string, i int, fn func(byte) bool) int {
+	for i < len(sql) && fn(sql[i]) {
+		i++
+	}
+
+	return i
+}
+
+func isSpace(c byte) bool {
+	return unicode.IsSpace(rune(c))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNumber(c byte) bool {
+	return isDigit(c) || c == '.' || c == 'e' || c == 'E'
+}
+
+func isWordStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isWord(c byte) bool {
+	return isWordStart(c) || isDigit(c) || c == '$'
+}
+
+// readStatements are the statements which only read, where EXPLAIN doesn't execute the statement it explains
+var readStatements = map[string]bool{
+	"SELECT":   true,
+	"VALUES":   true,
+	"EXPLAIN":  true,
+	"DESCRIBE": true,
+	"SHOW":     true,
+}
+
+// mainStatements are the statements which can follow the common table expressions of a WITH
+var mainStatements = map[string]bool{
+	"SELECT": true,
+	"VALUES": true,
+	"INSERT": true,
+	"UPDATE": true,
+	"DELETE": true,
+	"MERGE":  true,
+}
+
+// writeStatement returns the keyword of the first statement in the query which isn't a read, e.g. INSERT,
+// and false if every statement only reads. Statements are split on semicolons outside string literals,
+// quoted identifiers and comments, and statements which aren't known to be reads are treated as writes.
+func writeStatement(sql string) (string, bool) {
+	var statement []sqlToken
+	for _, t := range append(tokenizeSQL(sql), sqlToken{kind: tokenPunctuation, text: ";"}) {
+		switch {
+		case t.kind == tokenWhitespace || t.kind == tokenComment:
+			continue
+		case t.kind == tokenPunctuation && t.text == ";":
+			if keyword := statementKeyword(statement); keyword != "" && !readStatements[keyword] {
+				return keyword, true
+			}
+			statement = statement[:0]
+		default:
+			statement = append(statement, t)
+		}
+	}
+
+	return "", false
+}
+
+// statementKeyword returns the keyword of the statement, which for a WITH is the statement after the common table
+// expressions, or an empty string if the statement is empty
+func statementKeyword(tokens []sqlToken) string {
+	// a query can be wrapped in parentheses
+	for len(tokens) > 0 && tokens[0].text == "(" {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return ""
+	}
+	keyword := strings.ToUpper(tokens[0].text)
+	if tokens[0].kind != tokenWord || keyword != "WITH" {
+		return keyword
+	}
+
+	depth := 0
+	for _, t := range tokens[1:] {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth == 0 && t.kind == tokenWord && mainStatements[strings.ToUpper(t.text)]:
+			return strings.ToUpper(t.text)
+		}
+	}
+
+	return keyword
+}
+
+// redactSQL replaces all string literals in the query with '?', so the query can be logged without the values
+func redactSQL(sql string) string {
+	var b strings.Builder
+
+	for _, t := range tokenizeSQL(sql) {
+		if t.kind == tokenString {
+			b.WriteString("'?'")
+			continue
+		}
+		b.WriteString(t.text)
+	}
+
+	return b.String()
+}
+
+// sqlSnippet returns the line of the query at the 1-based line and column, with a caret under the column,
+// and one line of context on each side, or an empty string if the line isn't in the query
+func sqlSnippet(sql string, line, column int) string {
+	lines := strings.Split(sql, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	first, last := max(line-1, 1), min(line+1, len(lines))
+	width := len(strconv.Itoa(last))
+
+	var b strings.Builder
+	for n := first; n <= last; n++ {
+		text := strings.TrimRight(lines[n-1], "\r")
+		fmt.Fprintf(&b, "%*d | %s\n", width, n, text)
+		if n != line {
+			continue
+		}
+
+		// keep tabs, so the caret lines up with the column
+		var pad strings.Builder
+		for i, r := range []rune(text) {
+			if i >= column-1 {
+				break
+			}
+			if r == '\t' {
+				pad.WriteRune('\t')
+			} else {
+				pad.WriteRune(' ')
+			}
+		}
+		fmt.Fprintf(&b, "%*s | %s^\n", width, "", pad.String())
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,239 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+)
+
+const (
+	// DefaultStreamInterval is how often the query of a streaming panel is executed, unless set in the query
+	DefaultStreamInterval = 10 * time.Second
+	// MinStreamInterval is the shortest interval a streaming query can be executed at
+	MinStreamInterval = time.Second
+	// maxStreamAge is how long a stream stays registered without the query being executed again
+	maxStreamAge = 10 * time.Minute
+
+	streamPathPrefix = "tail/"
+)
+
+// streamQuery is the state needed to run a stream, which is registered when the query is first executed
+type streamQuery struct {
+	datasourceUID string
+	query         MetricsQueryModel
+	// watermark is the newest time the panel already has data for
+	watermark time.Time
+	// registered is when the query was last executed
+	registered time.Time
+}
+
+// registerStream registers a streaming metrics query, so it can be run when Grafana Live subscribes to it,
+// and sets the channel on the frames in the response so the frontend subscribes to it. The streams which
+// haven't been registered again for maxStreamAge are removed, as the panel subscribes right after the query,
+// and a stream which is running already has its query.
+func (d *RocksetDatasource) registerStream(pCtx backend.PluginContext, query backend.DataQuery, res backend.DataResponse) {
+	if res.Error != nil || pCtx.DataSourceInstanceSettings == nil {
+		return
+	}
+
+	var qm MetricsQueryModel
+	if err := json.Unmarshal(query.JSON, &qm); err != nil || !qm.Stream {
+		return
+	}
+
+	uid := pCtx.DataSourceInstanceSettings.UID
+	sum := sha256.Sum256(append([]byte(uid), query.JSON...))
+	path := streamPathPrefix + hex.EncodeToString(sum[:16])
+
+	now := time.Now()
+	d.streams.Range(func(k, v interface{}) bool {
+		if now.Sub(v.(streamQuery).registered) > maxStreamAge {
+			d.streams.Delete(k)
+		}
+		return true
+	})
+	d.streams.Store(path, streamQuery{
+		datasourceUID: uid,
+		query:         qm,
+		watermark:     query.TimeRange.To,
+		registered:    now,
+	})
+
+	channel := live.Channel{Scope: live.ScopeDatasource, Namespace: uid, Path: path}.String()
+	for _, frame := range res.Frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Channel = channel
+	}
+	log.DefaultLogger.Debug("registered stream", "refId", query.RefID, "channel", channel)
+}
+
+// SubscribeStream is called when a panel subscribes to the channel of a streaming query, and
+// only allows it if the stream is registered for the datasource and the datasource settings are valid.
+func (d *RocksetDatasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if _, err := loadSettings(req.PluginContext.DataSourceInstanceSettings); err != nil {
+		log.DefaultLogger.Error("stream subscription denied", "path", req.Path, "error", err.Error())
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+	}
+
+	v, found := d.streams.Load(req.Path)
+	if !found {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	if v.(streamQuery).datasourceUID != req.PluginContext.DataSourceInstanceSettings.UID {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusPermissionDenied}, nil
+	}
+
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream is called when a client tries to publish to a channel, which isn't supported.
+func (d *RocksetDatasource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream executes the query of the stream on an interval, using the time of the newest row as the
+// start time parameter, and sends the rows which are newer than what has already been sent.
+func (d *RocksetDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	v, found := d.streams.Load(req.Path)
+	if !found {
+		return fmt.Errorf("unknown stream: %s", req.Path)
+	}
+	defer d.streams.Delete(req.Path)
+	sq := v.(streamQuery)
+
+	settings, err := loadSettings(req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		return err
+	}
+
+	rs, err := d.newClient(ctx, settings, nil)
+	if err != nil {
+		return fmt.Errorf("could create Rockset datasource: %w", err)
+	}
+	rs = withLogging(withMetrics(rs, QueryTypeStream, sq.datasourceUID), settings)
+
+	interval := streamInterval(sq.query.StreamInterval)
+	log.DefaultLogger.Info("running stream", "path", req.Path, "interval", interval.String())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	watermark := sq.watermark
+	for {
+		select {
+		case <-ctx.Done():
+			log.DefaultLogger.Info("stream stopped", "path", req.Path)
+			return nil
+		case now := <-ticker.C:
+			frames, next, err := streamFrames(ctx, rs, settings.VI, sq.query, watermark, now)
+			if err != nil {
+				log.DefaultLogger.Error("stream query failed", "path", req.Path, "error", err.Error())
+				continue
+			}
+			watermark = next
+
+			for _, frame := range frames {
+				if err = sender.SendFrame(frame, data.IncludeAll); err != nil {
+					return fmt.Errorf("failed to send frame: %w", err)
+				}
+			}
+		}
+	}
+}
+
+// streamFrames executes the query for the time since the watermark, and returns frames with the rows
+// which are newer than the watermark, together with the new watermark
+func streamFrames(ctx context.Context, rs RockClient, vi string, qm MetricsQueryModel,
+	watermark, now time.Time) ([]*data.Frame, time.Time, error) {
+	options := buildQueryOptions(qm, watermark, now, vi)
+	qr, err := rs.Query(ctx, qm.QueryText, options...)
+	if err != nil {
+		return nil, watermark, err
+	}
+	logQueryResponse(qr)
+
+	timeColumn := qm.QueryTimeField
+	if timeColumn == "" {
+		timeColumn = DefaultTimeColumn
+	}
+
+	qr.Results, watermark, err = rowsAfter(timeColumn, watermark, qr.Results)
+	if err != nil {
+		return nil, watermark, err
+	}
+	if len(qr.Results) == 0 {
+		return nil, watermark, nil
+	}
+
+	quality := newDataQuality()
+	ss, err := extractSeries(ctx, timeColumn, qm.QueryLabelColumn, qr, quality)
+	if err != nil {
+		return nil, watermark, err
+	}
+
+	var frames []*data.Frame
+	for _, fields := range ss {
+		frame := makeFrame("metrics", qm.QueryText, qr)
+		frame.Fields = append(frame.Fields, fields...)
+		frames = append(frames, frame)
+	}
+	if len(frames) > 0 {
+		frames[0].AppendNotices(quality.notices()...)
+	}
+
+	return frames, watermark, nil
+}
+
+// rowsAfter returns the rows with a time after the watermark, and the time of the newest row
+func rowsAfter(timeColumn string, watermark time.Time,
+	results []map[string]interface{}) ([]map[string]interface{}, time.Time, error) {
+	var rows []map[string]interface{}
+	newest := watermark
+
+	for _, row := range results {
+		value, ok := row[timeColumn].(string)
+		if !ok {
+			return nil, watermark, fmt.Errorf("time column %s is missing or not a string", timeColumn)
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, watermark, fmt.Errorf("failed to convert %s to time: %w", value, err)
+		}
+		if !t.After(watermark) {
+			continue
+		}
+		rows = append(rows, row)
+		if t.After(newest) {
+			newest = t
+		}
+	}
+
+	return rows, newest, nil
+}
+
+func streamInterval(s string) time.Duration {
+	if s == "" {
+		return DefaultStreamInterval
+	}
+
+	interval, err := time.ParseDuration(s)
+	if err != nil {
+		log.DefaultLogger.Warn("invalid stream interval, using default", "interval", s, "error", err.Error())
+		return DefaultStreamInterval
+	}
+	if interval < MinStreamInterval {
+		return MinStreamInterval
+	}
+
+	return interval
+}
@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/rockset/rockset-go-client/openapi"
+	"github.com/rockset/rockset-go-client/option"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The span attributes set by the plugin
+const (
+	attributeRefID     = attribute.Key("grafana.ref_id")
+	attributeQueryType = attribute.Key("rockset.query_type")
+	attributeQueryID   = attribute.Key("rockset.query_id")
+	attributeRows      = attribute.Key("rockset.rows")
+	attributeStreamed  = attribute.Key("rockset.streamed")
+)
+
+// tracingClient is a RockClient which starts a span around each query
+type tracingClient struct {
+	RockClient
+	attributes []attribute.KeyValue
+}
+
+// withTracing wraps the RockClient so a span is started for each query, using the tracer provided by the plugin SDK
+func withTracing(rs RockClient, refID, queryType string) RockClient {
+	return &tracingClient{
+		RockClient: rs,
+		attributes: []attribute.KeyValue{attributeRefID.String(refID), attributeQueryType.String(queryType)},
+	}
+}
+
+// Query executes the query in a span, which records the Rockset query ID and the number of rows
+func (c *tracingClient) Query(ctx context.Context, sql string, options ...option.QueryOption) (openapi.QueryResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "rockset.Query",
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(c.attributes...))
+	defer span.End()
+
+	qr, err := c.RockClient.Query(ctx, sql, options...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return qr, err
+	}
+	span.SetAttributes(attributeQueryID.String(qr.GetQueryId()), attributeRows.Int(resultRows(qr)))
+
+	return qr, nil
+}
+
+// traceHeaders returns the headers which propagates the trace context of ctx to Rockset
+func traceHeaders(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	return carrier
+}
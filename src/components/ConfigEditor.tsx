@@ -1,8 +1,14 @@
 import React, { ChangeEvent } from 'react';
-import { InlineField, Input, SecretInput } from '@grafana/ui';
-import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
+import { InlineField, InlineSwitch, Input, SecretInput, Select } from '@grafana/ui';
+import { DataSourcePluginOptionsEditorProps, SelectableValue } from '@grafana/data';
 import { RocksetDataSourceOptions, RocksetSecureJsonData } from '../types';
 
+const logLevelOptions: Array<SelectableValue<RocksetDataSourceOptions['logLevel']>> = [
+  { label: 'Off', value: 'off', description: 'never log the SQL' },
+  { label: 'Debug', value: 'debug', description: 'log the SQL at debug level' },
+  { label: 'Info', value: 'info', description: 'log the SQL at info level' },
+];
+
 interface Props extends DataSourcePluginOptionsEditorProps<RocksetDataSourceOptions> {}
 
 export function ConfigEditor(props: Props) {
@@ -23,6 +29,96 @@ export function ConfigEditor(props: Props) {
     onOptionsChange({ ...options, jsonData });
   };
 
+  const onDisableUserIdentityChange = (event: React.FormEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      disableUserIdentity: !event.currentTarget.checked,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onLogLevelChange = (value: SelectableValue<RocksetDataSourceOptions['logLevel']>) => {
+    const jsonData = {
+      ...options.jsonData,
+      logLevel: value.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onRedactLogsChange = (event: React.FormEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      redactLogs: event.currentTarget.checked,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onLogSqlOnErrorChange = (event: React.FormEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      logSqlOnError: event.currentTarget.checked,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onAllowWritesChange = (event: React.FormEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      allowWrites: event.currentTarget.checked,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onCollectionsChange = (key: 'allowedCollections' | 'deniedCollections') =>
+      (event: ChangeEvent<HTMLInputElement>) => {
+        const patterns = event.target.value.split(',').map((p) => p.trim());
+        const jsonData = {
+          ...options.jsonData,
+          [key]: event.target.value === '' ? undefined : patterns,
+        };
+        onOptionsChange({ ...options, jsonData });
+      };
+
+  const onStreamResultsChange = (event: React.FormEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      streamResults: event.currentTarget.checked,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onMaxRowLimitChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      maxRowLimit: parseInt(event.target.value, 10) || 0,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onMaxResponseBytesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      maxResponseBytes: parseInt(event.target.value, 10) || 0,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onCacheTTLChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      cacheTTL: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
+  const onCacheBucketChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const jsonData = {
+      ...options.jsonData,
+      cacheBucket: event.target.value,
+    };
+    onOptionsChange({ ...options, jsonData });
+  };
+
   // Secure field (only sent to the backend)
   const onAPIKeyChange = (event: ChangeEvent<HTMLInputElement>) => {
     onOptionsChange({
@@ -85,6 +181,117 @@ export function ConfigEditor(props: Props) {
             />
           </InlineField>
         </div>
+        <div className="gf-form-group">
+          <InlineField label="Send User Identity" labelWidth={30}
+                       tooltip={"send the Grafana user with the dashboard and panel of each query, so it shows up in the Rockset query log"}>
+            <InlineSwitch
+                value={!jsonData.disableUserIdentity}
+                onChange={onDisableUserIdentityChange}
+            />
+          </InlineField>
+        </div>
+        <div className="gf-form-group">
+          <InlineField label="Allow Writes" labelWidth={30}
+                       tooltip={"allow queries which write, like INSERT INTO or DELETE, which otherwise are rejected"}>
+            <InlineSwitch
+                value={jsonData.allowWrites || false}
+                onChange={onAllowWritesChange}
+            />
+          </InlineField>
+          <InlineField label="Allowed Collections" labelWidth={30}
+                       tooltip={"comma separated workspace.collection patterns queries can use, e.g. analytics.*, empty allows all"}>
+            <Input
+                onChange={onCollectionsChange('allowedCollections')}
+                value={(jsonData.allowedCollections || []).join(',')}
+                placeholder="all collections"
+                width={40}
+            />
+          </InlineField>
+          <InlineField label="Denied Collections" labelWidth={30}
+                       tooltip={"comma separated workspace.collection patterns queries can't use, e.g. *.secret_*"}>
+            <Input
+                onChange={onCollectionsChange('deniedCollections')}
+                value={(jsonData.deniedCollections || []).join(',')}
+                placeholder="none"
+                width={40}
+            />
+          </InlineField>
+        </div>
+        <div className="gf-form-group">
+          <InlineField label="Log SQL" labelWidth={30}
+                       tooltip={"the level the SQL and query JSON are logged at in the Grafana server log"}>
+            <Select
+                options={logLevelOptions}
+                onChange={onLogLevelChange}
+                value={jsonData.logLevel || 'debug'}
+                width={30}
+            />
+          </InlineField>
+          <InlineField label="Redact Logs" labelWidth={30}
+                       tooltip={"remove string literals and parameter values from the SQL and query JSON before they are logged"}>
+            <InlineSwitch
+                value={jsonData.redactLogs || false}
+                onChange={onRedactLogsChange}
+            />
+          </InlineField>
+          <InlineField label="Log SQL Only On Error" labelWidth={30}
+                       tooltip={"only log the SQL when a query fails, at error level"}>
+            <InlineSwitch
+                value={jsonData.logSqlOnError || false}
+                onChange={onLogSqlOnErrorChange}
+            />
+          </InlineField>
+        </div>
+        <div className="gf-form-group">
+          <InlineField label="Stream Results" labelWidth={30}
+                       tooltip={"decode the rows of metric queries as the response is read, which uses less memory for large results"}>
+            <InlineSwitch
+                value={jsonData.streamResults || false}
+                onChange={onStreamResultsChange}
+            />
+          </InlineField>
+          <InlineField label="Max Response Bytes" labelWidth={30}
+                       tooltip={"stop reading streamed responses after this many bytes, 0 is no limit"}>
+            <Input
+                type="number"
+                min={0}
+                onChange={onMaxResponseBytesChange}
+                value={jsonData.maxResponseBytes || 0}
+                width={30}
+                disabled={!jsonData.streamResults}
+            />
+          </InlineField>
+          <InlineField label="Max Row Limit" labelWidth={30}
+                       tooltip={"the most rows a query returns, which queries can lower but not raise, 0 is no limit"}>
+            <Input
+                type="number"
+                min={0}
+                onChange={onMaxRowLimitChange}
+                value={jsonData.maxRowLimit || 0}
+                width={30}
+            />
+          </InlineField>
+        </div>
+        <div className="gf-form-group">
+          <InlineField label="Cache TTL" labelWidth={30}
+                       tooltip={"how long the responses of queries are cached for, e.g. 30s, empty turns off caching"}>
+            <Input
+                onChange={onCacheTTLChange}
+                value={jsonData.cacheTTL || ''}
+                placeholder="off"
+                width={30}
+            />
+          </InlineField>
+          <InlineField label="Cache Time Bucket" labelWidth={30}
+                       tooltip={"the time range of queries is aligned to this in the cache key, so a slightly later time range uses the cached response"}>
+            <Input
+                onChange={onCacheBucketChange}
+                value={jsonData.cacheBucket || ''}
+                placeholder="1m"
+                width={30}
+            />
+          </InlineField>
+        </div>
       </div>
   );
 }
@@ -1,11 +1,32 @@
 import React, {ChangeEvent} from 'react';
-import {InlineField, Input, TextArea} from '@grafana/ui';
-import {QueryEditorProps} from '@grafana/data';
+import {InlineField, InlineSwitch, Input, Select, TextArea} from '@grafana/ui';
+import {QueryEditorProps, SelectableValue} from '@grafana/data';
 import {DataSource} from '../datasource';
 import {RocksetDataSourceOptions, RocksetQuery} from '../types';
 
 type Props = QueryEditorProps<DataSource, RocksetQuery, RocksetDataSourceOptions>;
 
+const queryPlanOptions: Array<SelectableValue<RocksetQuery['queryPlan']>> = [
+    {label: 'None', value: ''},
+    {label: 'Explain', value: 'explain', description: 'attach the EXPLAIN output to the query inspector'},
+    {label: 'Node graph', value: 'graph', description: 'also return the EXPLAIN output as a node graph'},
+    {label: 'Profile', value: 'profile', description: 'attach the query profile to the query inspector'},
+];
+
+const fillOptions: Array<SelectableValue<RocksetQuery['fill']>> = [
+    {label: 'None', value: ''},
+    {label: 'Null', value: 'null', description: 'fill the missing time buckets with null'},
+    {label: 'Zero', value: 'zero', description: 'fill the missing time buckets with 0'},
+    {label: 'Previous', value: 'previous', description: 'fill the missing time buckets with the previous value'},
+];
+
+const downsampleOptions: Array<SelectableValue<RocksetQuery['downsample']>> = [
+    {label: 'None', value: ''},
+    {label: 'LTTB', value: 'lttb', description: 'keep the points which best preserve the shape of each series'},
+    {label: 'Average', value: 'avg', description: 'average the values of each bucket'},
+    {label: 'Min/max', value: 'minmax', description: 'keep the smallest and largest value of each bucket'},
+];
+
 export function QueryEditor({query, onChange, onRunQuery}: Props) {
     const onQueryParamStartChange = (event: ChangeEvent<HTMLInputElement>) => {
         onChange({...query, queryParamStart: event.target.value});
@@ -27,12 +48,62 @@ export function QueryEditor({query, onChange, onRunQuery}: Props) {
         onRunQuery();
     };
 
+    const onQueryPlanChange = (value: SelectableValue<RocksetQuery['queryPlan']>) => {
+        onChange({...query, queryPlan: value.value});
+        onRunQuery();
+    };
+
+    const onFillChange = (value: SelectableValue<RocksetQuery['fill']>) => {
+        onChange({...query, fill: value.value});
+        onRunQuery();
+    };
+
+    const onDownsampleChange = (value: SelectableValue<RocksetQuery['downsample']>) => {
+        onChange({...query, downsample: value.value});
+        onRunQuery();
+    };
+
+    const onRowLimitChange = (event: ChangeEvent<HTMLInputElement>) => {
+        onChange({...query, rowLimit: parseInt(event.target.value, 10) || undefined});
+    };
+
+    const onStreamChange = (event: React.FormEvent<HTMLInputElement>) => {
+        onChange({...query, stream: event.currentTarget.checked});
+        onRunQuery();
+    };
+
+    const onStreamIntervalChange = (event: ChangeEvent<HTMLInputElement>) => {
+        onChange({...query, streamInterval: event.target.value});
+        onRunQuery();
+    };
+
+    const onIncrementalChange = (event: React.FormEvent<HTMLInputElement>) => {
+        onChange({...query, incremental: event.currentTarget.checked});
+        onRunQuery();
+    };
+
+    const onIncrementalOverlapChange = (event: ChangeEvent<HTMLInputElement>) => {
+        onChange({...query, incrementalOverlap: event.target.value});
+    };
+
+    const onChunkIntervalChange = (event: ChangeEvent<HTMLInputElement>) => {
+        onChange({...query, chunkInterval: event.target.value});
+    };
+
+    const onTimeOffsetsChange = (event: ChangeEvent<HTMLInputElement>) => {
+        onChange({...query, timeOffsets: event.target.value === '' ? undefined : event.target.value.split(',')});
+    };
+
+    const onCacheTTLChange = (event: ChangeEvent<HTMLInputElement>) => {
+        onChange({...query, cacheTTL: event.target.value});
+    };
+
     const onQueryTextChange = (event: ChangeEvent<HTMLTextAreaElement>) => {
         onChange({...query, queryText: event.target.value});
         onRunQuery();
     };
 
-    const {queryText, queryParamStart, queryParamStop, queryTimeField, queryLabelColumn} = query;
+    const {queryText, queryParamStart, queryParamStop, queryTimeField, queryLabelColumn, queryPlan, stream, streamInterval, cacheTTL, incremental, incrementalOverlap, chunkInterval, timeOffsets, fill, downsample, rowLimit} = query;
     const labelWidth = 16, fieldWidth = 20;
 
     return (
@@ -82,6 +153,139 @@ export function QueryEditor({query, onChange, onRunQuery}: Props) {
                         width={fieldWidth}
                     />
                 </InlineField>
+                <InlineField
+                    label="Query Plan"
+                    labelWidth={labelWidth}
+                    tooltip="Include the Rockset query plan or profile in the response"
+                >
+                    <Select
+                        options={queryPlanOptions}
+                        onChange={onQueryPlanChange}
+                        value={queryPlan || ''}
+                        width={fieldWidth}
+                    />
+                </InlineField>
+                <InlineField
+                    label="Fill"
+                    labelWidth={labelWidth}
+                    tooltip="Fill the time buckets of :interval which have no rows"
+                >
+                    <Select
+                        options={fillOptions}
+                        onChange={onFillChange}
+                        value={fill || ''}
+                        width={fieldWidth}
+                    />
+                </InlineField>
+                <InlineField
+                    label="Downsample"
+                    labelWidth={labelWidth}
+                    tooltip="Reduce each series to the max data points of the panel"
+                >
+                    <Select
+                        options={downsampleOptions}
+                        onChange={onDownsampleChange}
+                        value={downsample || ''}
+                        width={fieldWidth}
+                    />
+                </InlineField>
+                <InlineField
+                    label="Row Limit"
+                    labelWidth={labelWidth}
+                    tooltip="The most rows the query returns, unless the SQL has a LIMIT"
+                >
+                    <Input
+                        type="number"
+                        min={0}
+                        onChange={onRowLimitChange}
+                        onBlur={onRunQuery}
+                        value={rowLimit || ''}
+                        placeholder="Rockset default"
+                        width={fieldWidth}
+                    />
+                </InlineField>
+                <InlineField
+                    label="Stream"
+                    labelWidth={labelWidth}
+                    tooltip="Update the panel live with new rows, instead of querying the whole time range again"
+                >
+                    <InlineSwitch value={stream || false} onChange={onStreamChange}/>
+                </InlineField>
+                <InlineField
+                    label="Incremental"
+                    labelWidth={labelWidth}
+                    tooltip="Only query the time since the previous refresh, and merge the new rows with the previous result"
+                >
+                    <InlineSwitch value={incremental || false} onChange={onIncrementalChange}/>
+                </InlineField>
+                {incremental && (
+                    <InlineField
+                        label="Overlap"
+                        labelWidth={labelWidth}
+                        tooltip="How far before the previous refresh to query again, to pick up rows which arrived late, e.g. 1m"
+                    >
+                        <Input
+                            onChange={onIncrementalOverlapChange}
+                            onBlur={onRunQuery}
+                            value={incrementalOverlap || ''}
+                            placeholder="1m"
+                            width={fieldWidth}
+                        />
+                    </InlineField>
+                )}
+                <InlineField
+                    label="Chunk Interval"
+                    labelWidth={labelWidth}
+                    tooltip="Split long time ranges into chunks of this duration, e.g. 24h, which are queried in parallel"
+                >
+                    <Input
+                        onChange={onChunkIntervalChange}
+                        onBlur={onRunQuery}
+                        value={chunkInterval || ''}
+                        placeholder="off"
+                        width={fieldWidth}
+                    />
+                </InlineField>
+                <InlineField
+                    label="Time Offsets"
+                    labelWidth={labelWidth}
+                    tooltip="Comma separated offsets, e.g. 1d,1w, to also show the series of earlier time ranges, with an offset label"
+                >
+                    <Input
+                        onChange={onTimeOffsetsChange}
+                        onBlur={onRunQuery}
+                        value={(timeOffsets || []).join(',')}
+                        placeholder="none"
+                        width={fieldWidth}
+                    />
+                </InlineField>
+                <InlineField
+                    label="Cache TTL"
+                    labelWidth={labelWidth}
+                    tooltip="How long the response is cached for, e.g. 30s, overriding the datasource setting. 0s turns off caching"
+                >
+                    <Input
+                        onChange={onCacheTTLChange}
+                        onBlur={onRunQuery}
+                        value={cacheTTL || ''}
+                        placeholder="datasource default"
+                        width={fieldWidth}
+                    />
+                </InlineField>
+                {stream && (
+                    <InlineField
+                        label="Stream Interval"
+                        labelWidth={labelWidth}
+                        tooltip="How often to query for new rows, e.g. 10s"
+                    >
+                        <Input
+                            onChange={onStreamIntervalChange}
+                            value={streamInterval || ''}
+                            placeholder="10s"
+                            width={fieldWidth}
+                        />
+                    </InlineField>
+                )}
             </div>
             <div>
                 <InlineField
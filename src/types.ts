@@ -7,6 +7,17 @@ export interface RocksetQuery extends DataQuery {
     queryParamStop: string;
     queryTimeField: string;
     queryLabelColumn: string;
+    queryPlan?: '' | 'explain' | 'graph' | 'profile';
+    stream?: boolean;
+    streamInterval?: string;
+    cacheTTL?: string;
+    incremental?: boolean;
+    incrementalOverlap?: string;
+    chunkInterval?: string;
+    timeOffsets?: string[];
+    fill?: '' | 'null' | 'zero' | 'previous';
+    downsample?: '' | 'lttb' | 'avg' | 'minmax';
+    rowLimit?: number;
 }
 
 export const DEFAULT_QUERY: Partial<RocksetQuery> = {
@@ -20,7 +31,7 @@ FROM
 -- you MUST specify a WHERE clause which scopes the query using :startTime and :stopTime
 -- as the Rockset plugin executes the query with these parameters
 WHERE
-  e._event_time > :startTime AND
+  e._event_time >= :startTime AND
   e._event_time < :stopTime
 GROUP BY
   _event_time,
@@ -39,6 +50,18 @@ ORDER BY
 export interface RocksetDataSourceOptions extends DataSourceJsonData {
     server?: string;
     vi?: string;
+    disableUserIdentity?: boolean;
+    logLevel?: 'off' | 'debug' | 'info';
+    redactLogs?: boolean;
+    logSqlOnError?: boolean;
+    allowWrites?: boolean;
+    allowedCollections?: string[];
+    deniedCollections?: string[];
+    streamResults?: boolean;
+    maxResponseBytes?: number;
+    maxRowLimit?: number;
+    cacheTTL?: string;
+    cacheBucket?: string;
 }
 
 /**